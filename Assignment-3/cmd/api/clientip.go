@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses the -trusted-proxies flag, a list of CIDR ranges such as
+// "10.0.0.0/8 fd00::/8", separated by spaces or commas. A plain IP address is treated
+// as a range holding just that address.
+func parseTrustedProxies(val string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, field := range strings.FieldsFunc(val, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q (must be a CIDR range or IP address)", field)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q (must be a CIDR range or IP address)", field)
+		}
+		proxies = append(proxies, ipNet)
+	}
+
+	return proxies, nil
+}
+
+// clientIP works out the IP address of the client that made the request. Unless the
+// request came from one of the trusted proxies, that is the address of the peer, and
+// forwarding headers are ignored, since anyone can send them.
+//
+// If it did come from a trusted proxy, the addresses the proxies recorded are walked
+// from the right, that is starting with the one added by the proxy nearest to us, and
+// the first address that isn't a trusted proxy is the client. Only the header named by
+// the -trusted-proxy-header flag is read: the Forwarded header (RFC 7239) or
+// X-Forwarded-For. Proxies generally add to one of them and pass the other on from the
+// client as it is, so reading both would let a client choose its own address. If a
+// trusted proxy recorded something that isn't an IP address, such as "unknown", the
+// proxy itself is taken to be the client, as nothing further along can be relied on.
+func (app *application) clientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if !app.isTrustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	switch app.config.trustedProxyHeader {
+	case "forwarded":
+		hops = parseForwardedFor(r.Header.Values("Forwarded"))
+	default:
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHopIP(hops[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (app *application) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range app.config.trustedProxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP strips the port from a peer address. The address is returned as it is if
+// there is no port, which can happen in tests.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// parseHopIP returns the IP address in an X-Forwarded-For entry or the value of a
+// Forwarded for= parameter, which may have a port and, for IPv6, square brackets, as in
+// "192.0.2.43:47011" or "[2001:db8:cafe::17]:4711". It returns "" if there is no IP
+// address, for "unknown" or an obfuscated identifier such as "_hidden".
+func parseHopIP(hop string) string {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+
+	ip := net.ParseIP(hop)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// parseForwardedFor returns the for= parameter of each element of the Forwarded
+// headers, in order. Elements are separated by commas and their parameters by
+// semicolons, and values may be quoted strings. An element without a for= parameter
+// gives "", which stops the search for the client in clientIP().
+func parseForwardedFor(values []string) []string {
+	var hops []string
+
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			hop := ""
+			for _, pair := range splitQuoted(element, ';') {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = unquote(strings.TrimSpace(val))
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// splitQuoted splits s at each sep that isn't inside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes, and any backslash escapes, from a quoted string. Other
+// values are returned as they are.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	s = s[1 : len(s)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		forwarded  []string
+		xff        []string
+		want       string
+	}{
+		{
+			name:       "no headers",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "untrusted peer sending X-Forwarded-For",
+			header:     "x-forwarded-for",
+			remoteAddr: "198.51.100.7:1234",
+			xff:        []string{"1.2.3.4"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "untrusted peer sending Forwarded",
+			header:     "forwarded",
+			remoteAddr: "198.51.100.7:1234",
+			forwarded:  []string{"for=1.2.3.4"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "one trusted hop",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "spoofed leftmost X-Forwarded-For hops",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"1.2.3.4, 10.9.9.9", "203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "chain of trusted hops",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"1.2.3.4, 203.0.113.9, 10.1.1.1, 10.2.2.2"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "every hop trusted",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"10.1.1.1, 10.2.2.2"},
+			want:       "10.1.1.1",
+		},
+		{
+			name:       "client Forwarded ignored when the proxy sets X-Forwarded-For",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			forwarded:  []string{"for=1.2.3.4"},
+			xff:        []string{"203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "client X-Forwarded-For ignored when the proxy sets Forwarded",
+			header:     "forwarded",
+			remoteAddr: "10.0.0.5:1234",
+			forwarded:  []string{"for=203.0.113.9"},
+			xff:        []string{"1.2.3.4"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "spoofed leftmost Forwarded elements",
+			header:     "forwarded",
+			remoteAddr: "10.0.0.5:1234",
+			forwarded:  []string{"for=1.2.3.4, for=10.9.9.9;proto=https", "for=203.0.113.9;by=10.0.0.5"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "quoted IPv6 with a port in Forwarded",
+			header:     "forwarded",
+			remoteAddr: "[fd00::1]:1234",
+			forwarded:  []string{`for="[2001:db8:cafe::17]:4711"`},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "quoted parameter containing separators",
+			header:     "forwarded",
+			remoteAddr: "10.0.0.5:1234",
+			forwarded:  []string{`for="[2001:db8::1]";proto=https;host="a,b;c"`},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "bracketed IPv6 with a port in X-Forwarded-For",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"[2001:db8::2]:8080"},
+			want:       "2001:db8::2",
+		},
+		{
+			name:       "IPv4 with a port",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"192.0.2.43:47011"},
+			want:       "192.0.2.43",
+		},
+		{
+			name:       "unknown stops at the proxy",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{"1.2.3.4, unknown, 10.1.1.1"},
+			want:       "10.1.1.1",
+		},
+		{
+			name:       "obfuscated identifier stops at the proxy",
+			header:     "forwarded",
+			remoteAddr: "10.0.0.5:1234",
+			forwarded:  []string{"for=1.2.3.4, for=_hidden"},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "element without for= stops at the proxy",
+			header:     "forwarded",
+			remoteAddr: "10.0.0.5:1234",
+			forwarded:  []string{"for=1.2.3.4, proto=https"},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "garbage",
+			header:     "x-forwarded-for",
+			remoteAddr: "10.0.0.5:1234",
+			xff:        []string{`1.2.3.4, "><script>`},
+			want:       "10.0.0.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			app.config.trustedProxies = proxies
+			app.config.trustedProxyHeader = tt.header
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("Forwarded", value)
+			}
+			for _, value := range tt.xff {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8 192.0.2.1,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::1/128"}
+	if len(proxies) != len(want) {
+		t.Fatalf("got %d proxies; want %d", len(proxies), len(want))
+	}
+	for i := range want {
+		if got := proxies[i].String(); got != want[i] {
+			t.Errorf("proxy %d: got %s; want %s", i, got, want[i])
+		}
+	}
+
+	for _, val := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := parseTrustedProxies(val); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded; want an error", val)
+		}
+	}
+}
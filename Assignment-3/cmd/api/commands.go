@@ -0,0 +1,246 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The longest a device may wait for a command in a single long-poll request. This has
+// to stay comfortably below the server's WriteTimeout.
+const maxCommandWait = 25 * time.Second
+
+// commandInput is the request body for createCommandHandler, and the command object
+// in control messages sent over the live WebSocket.
+type commandInput struct {
+	Type       string `json:"type"`
+	SpeedLimit *int32 `json:"speed_limit"`
+	TTL        string `json:"ttl"`
+}
+
+// newCommand builds a command from the input and validates it, recording any problems
+// in v. It is shared by the REST and WebSocket APIs so that both accept exactly the
+// same commands.
+func (app *application) newCommand(remoteCarID int64, user *data.User, input commandInput, v *validator.Validator) *data.Command {
+	ttl := time.Minute
+	if input.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(input.TTL)
+		if err != nil {
+			v.AddError("ttl", validator.Duration)
+			return nil
+		}
+	}
+
+	command := &data.Command{
+		RemoteCarID: remoteCarID,
+		UserID:      user.ID,
+		Type:        input.Type,
+		SpeedLimit:  input.SpeedLimit,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+
+	data.ValidateCommand(v, command)
+
+	return command
+}
+
+// createCommandHandler queues a command for a remote car.
+//
+// TODO: only the "remote-cars:control" permission is checked. The renter with the
+// current booking of the car should be allowed too, once bookings exist.
+func (app *application) createCommandHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input commandInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	command := app.newCommand(id, app.contextGetUser(r), input, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Commands.Insert(command)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/remote-cars/%d/commands/%d", id, command.ID))
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"command": command}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCommandHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	commandID, err := app.readInt64Param(r, "command_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	command, err := app.modelsFor(r).Commands.Get(id, commandID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"command": command}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// nextCommandHandler is long-polled by the device in a remote car. If a command is
+// already queued it is returned straight away; otherwise the request is held open for
+// up to the requested wait time, checking for new commands once a second, and a 204
+// No Content response is sent if nothing turns up.
+func (app *application) nextCommandHandler(w http.ResponseWriter, r *http.Request) {
+	device := app.contextGetDevice(r)
+
+	v := validator.New()
+
+	wait := app.readDuration(r.URL.Query(), "wait", 20*time.Second, v)
+
+	v.Check(wait >= 0, "wait", validator.NotNegative)
+	v.Check(wait <= maxCommandWait, "wait", validator.MaxDuration, maxCommandWait.String())
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	deadline := time.Now().Add(wait)
+
+	for {
+		command, err := app.modelsFor(r).Commands.ClaimNext(device.RemoteCarID)
+		if err == nil {
+			err = app.writeJSON(w, http.StatusOK, envelope{"command": command}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// acknowledgeCommandHandler lets the device report whether it carried out a command
+// that it received from nextCommandHandler.
+func (app *application) acknowledgeCommandHandler(w http.ResponseWriter, r *http.Request) {
+	device := app.contextGetDevice(r)
+
+	commandID, err := app.readInt64Param(r, "command_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	command, err := app.modelsFor(r).Commands.Get(device.RemoteCarID, commandID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Success || input.Error != "", "error", validator.RequiredOnFailure)
+	v.Check(!input.Success || input.Error == "", "error", validator.ForbiddenOnSuccess)
+	v.Check(len(input.Error) <= 1000, "error", validator.MaxBytes, 1000)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	state := data.CommandAcknowledged
+	if !input.Success {
+		state = data.CommandFailed
+	}
+
+	err = app.modelsFor(r).Commands.Complete(command, state, input.Error)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrCommandNotPending):
+			app.commandNotPendingResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"command": command}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
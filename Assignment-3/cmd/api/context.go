@@ -14,10 +14,56 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// deviceContextKey is used in the same way for the Device struct of a remote car that
+// authenticated with a device key.
+const deviceContextKey = contextKey("device")
+
+// requestInfoContextKey holds a *requestInfo for every request, added by the
+// logRequest() middleware.
+const requestInfoContextKey = contextKey("requestInfo")
+
+// requestInfo collects the details of a request that are needed for the access log and
+// the metrics. It is shared by pointer so that middleware and handlers further down
+// the chain can fill it in: the trace() middleware sets the trace ID, the router the
+// route pattern, contextSetUser() the user ID, and the responseRecorder the status code
+// and size of the response. The client IP is worked out by logRequest() at the start.
+type requestInfo struct {
+	id       string
+	clientIP string
+	traceID  string
+	route    string
+	userID   int64
+	status   int
+	bytes    int
+}
+
+// The contextGetRequestInfo() method retrieves the requestInfo from the request
+// context. It returns an empty requestInfo rather than nil when there isn't one, so
+// that callers don't need to check.
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
+
+// The contextGetClientIP() method returns the IP address of the client, taking trusted
+// proxies into account.
+func (app *application) contextGetClientIP(r *http.Request) string {
+	if ip := app.contextGetRequestInfo(r).clientIP; ip != "" {
+		return ip
+	}
+	return app.clientIP(r)
+}
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if !user.IsAnonymous() {
+		app.contextGetRequestInfo(r).userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -33,3 +79,17 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetDevice() method adds the authenticated Device to the request context.
+func (app *application) contextSetDevice(r *http.Request, device *data.Device) *http.Request {
+	ctx := context.WithValue(r.Context(), deviceContextKey, device)
+	return r.WithContext(ctx)
+}
+
+// The contextGetDevice() method retrieves the Device from the request context. Unlike
+// contextGetUser() it returns nil when there isn't one, because most requests are made
+// by users rather than devices.
+func (app *application) contextGetDevice(r *http.Request) *data.Device {
+	device, _ := r.Context().Value(deviceContextKey).(*data.Device)
+	return device
+}
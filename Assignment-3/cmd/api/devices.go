@@ -0,0 +1,167 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// createDeviceHandler provisions the hardware for a remote car. The response contains
+// the device's API key, which is never shown again, so it has to be copied onto the
+// device straight away.
+func (app *application) createDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		HardwareID  string `json:"hardware_id"`
+		RemoteCarID int64  `json:"remote_car_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	device := &data.Device{
+		HardwareID:  input.HardwareID,
+		RemoteCarID: input.RemoteCarID,
+	}
+
+	v := validator.New()
+
+	if data.ValidateDevice(v, device); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(device.RemoteCarID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("remote_car_id", validator.ExistingRemoteCar)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	key, err := app.modelsFor(r).Devices.Insert(device)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateHardwareID):
+			v.AddError("hardware_id", validator.DuplicateHardware)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/devices/%d", device.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"device": device, "device_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	device, err := app.modelsFor(r).Devices.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"device": device}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices, err := app.modelsFor(r).Devices.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"devices": devices}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rotateDeviceKeyHandler issues a new API key for a device, immediately revoking the
+// old one.
+func (app *application) rotateDeviceKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	device, err := app.modelsFor(r).Devices.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	key, err := app.modelsFor(r).Devices.RotateKey(device)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"device": device, "device_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).Devices.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "device successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
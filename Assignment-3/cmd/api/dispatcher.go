@@ -0,0 +1,263 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/tracing"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookPollInterval   = 5 * time.Second
+	webhookBatchSize      = 10
+	webhookTimeout        = 10 * time.Second
+	webhookEventRetention = 30 * 24 * time.Hour
+)
+
+// webhookPayload is the JSON body posted to a webhook. The ID identifies the event, not
+// the delivery, so receivers can use it to ignore an event that is sent twice.
+type webhookPayload struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// dispatchWebhooks runs until the done channel is closed. Every few seconds it turns
+// new outbox events into deliveries for the subscribed webhooks, and then sends the
+// deliveries that are due, several at a time. Failed deliveries are retried by
+// data.WebhookModel.RecordAttempt() with exponential backoff.
+func (app *application) dispatchWebhooks(done <-chan struct{}) {
+	client := newWebhookClient(app.config.webhooks.allowPrivate)
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-prune.C:
+			err := app.models.Webhooks.DeleteOldEvents(webhookEventRetention)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			continue
+		case <-ticker.C:
+		}
+
+		err := app.models.Webhooks.Fanout()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		deliveries, err := app.models.Webhooks.ClaimDue(webhookBatchSize)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		var wg sync.WaitGroup
+
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery *data.PendingWebhookDelivery) {
+				defer wg.Done()
+				app.sendWebhook(client, delivery)
+			}(delivery)
+		}
+
+		wg.Wait()
+	}
+}
+
+// errPrivateWebhookAddress is the error for a webhook whose URL resolves to an address
+// that isn't on the public internet.
+var errPrivateWebhookAddress = errors.New("webhook URL resolves to a private or local address")
+
+// blockedWebhookRanges are the ranges, on top of the loopback, private, link-local and
+// multicast ones, that webhooks can't be delivered to: addresses that are reserved,
+// shared with the ISP, or that translate to IPv4 addresses which might be private.
+var blockedWebhookRanges = func() []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"100.64.0.0/10",
+		"192.0.0.0/24",
+		"198.18.0.0/15",
+		"240.0.0.0/4",
+		"64:ff9b::/96",
+		"2002::/16",
+	} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges
+}()
+
+// newWebhookClient returns the HTTP client that webhooks are delivered with.
+//
+// Anyone who can register a webhook chooses where the dispatcher sends requests, so
+// unless allowPrivate is set, which is only meant for development, the client refuses
+// to connect to anything but public addresses. Otherwise a webhook could be pointed at
+// the cloud metadata service on 169.254.169.254, or at services on our own network.
+// The address is checked when the connection is made, after DNS resolution, so a
+// hostname that resolves to a public address when the webhook is registered and a
+// private one later is caught too. Proxies aren't used, as the check would then see
+// the proxy's address instead.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateWebhookAddress, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		// A redirect is treated as a failed delivery rather than followed, so that the
+		// payload is only ever sent to the URL that was registered.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPublicIP reports whether webhooks may be delivered to the address.
+func isPublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, ipNet := range blockedWebhookRanges {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendWebhook makes one attempt to deliver an event, with deliverWebhook(), and records
+// the outcome.
+func (app *application) sendWebhook(client *http.Client, delivery *data.PendingWebhookDelivery) {
+	// Webhook events are recorded by database triggers, so they can't be linked to the
+	// request that caused them; each delivery starts a trace of its own, which the
+	// receiver can continue from the traceparent header.
+	ctx, span := app.tracer.Start(context.Background(), "deliver webhook", tracing.SpanKindClient,
+		tracing.Int64("webhook.delivery_id", delivery.ID),
+		tracing.String("webhook.event_type", delivery.EventType),
+		tracing.String("http.request.method", http.MethodPost),
+	)
+	defer span.End()
+
+	attempt, succeeded := deliverWebhook(ctx, client, delivery, span.SpanContext().Traceparent())
+
+	if attempt.ResponseStatus != nil {
+		span.SetAttributes(tracing.Int("http.response.status_code", *attempt.ResponseStatus))
+	}
+	if !succeeded {
+		span.SetError(errors.New(attempt.Error))
+	}
+
+	err := app.models.WithTrace(ctx).Webhooks.RecordAttempt(delivery, attempt, succeeded)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"webhook_delivery": strconv.FormatInt(delivery.ID, 10)})
+	}
+}
+
+// deliverWebhook posts an event to a webhook and reports how it went. Any 2xx response
+// counts as success.
+//
+// The request is signed so that the receiver can check that it came from us and
+// hasn't been tampered with: the X-Webhook-Signature header holds "sha256=" followed
+// by the hex encoded HMAC-SHA256, keyed with the webhook's secret, of the
+// X-Webhook-Timestamp header value, a ".", and the raw request body. Including the
+// timestamp lets receivers reject old requests being replayed.
+func deliverWebhook(ctx context.Context, client *http.Client, delivery *data.PendingWebhookDelivery, traceparent string) (*data.WebhookAttempt, bool) {
+	attempt := &data.WebhookAttempt{AttemptedAt: time.Now()}
+	defer func() {
+		attempt.Duration = time.Since(attempt.AttemptedAt).Milliseconds()
+	}()
+
+	body, err := json.Marshal(webhookPayload{
+		ID:        delivery.EventID,
+		Type:      delivery.EventType,
+		CreatedAt: delivery.OccurredAt,
+		Data:      delivery.Data,
+	})
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+
+	timestamp := strconv.FormatInt(attempt.AttemptedAt.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(delivery.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	if traceparent != "" {
+		req.Header.Set("traceparent", traceparent)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "remote-cars-webhooks/"+version)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signature)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	status := resp.StatusCode
+	attempt.ResponseStatus = &status
+
+	if status < 200 || status >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected response status %d", status)
+		return attempt, false
+	}
+
+	return attempt, true
+}
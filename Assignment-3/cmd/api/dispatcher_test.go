@@ -0,0 +1,207 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDelivery(url string) *data.PendingWebhookDelivery {
+	return &data.PendingWebhookDelivery{
+		ID:         7,
+		URL:        url,
+		Secret:     "0123456789abcdef",
+		EventID:    42,
+		EventType:  "remote_car.updated",
+		OccurredAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Data:       json.RawMessage(`{"id":1}`),
+	}
+}
+
+func TestDeliverWebhookSignature(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+	)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	delivery := newTestDelivery(receiver.URL)
+
+	attempt, succeeded := deliverWebhook(context.Background(), newWebhookClient(true), delivery, "")
+	if !succeeded {
+		t.Fatalf("delivery failed: %s", attempt.Error)
+	}
+	if attempt.ResponseStatus == nil || *attempt.ResponseStatus != http.StatusNoContent {
+		t.Errorf("got response status %v; want %d", attempt.ResponseStatus, http.StatusNoContent)
+	}
+
+	// The receiver checks the signature the way the documentation tells it to: the
+	// HMAC of the timestamp, a ".", and the body exactly as received.
+	timestamp := header.Get("X-Webhook-Timestamp")
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("got X-Webhook-Timestamp %q; want a Unix time", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(delivery.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := header.Get("X-Webhook-Signature"); got != want {
+		t.Errorf("got X-Webhook-Signature %q; want %q", got, want)
+	}
+
+	for name, want := range map[string]string{
+		"Content-Type":       "application/json",
+		"X-Webhook-Event":    "remote_car.updated",
+		"X-Webhook-Delivery": "7",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("got %s %q; want %q", name, got, want)
+		}
+	}
+
+	var payload webhookPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != delivery.EventID || payload.Type != delivery.EventType || string(payload.Data) != `{"id":1}` {
+		t.Errorf("got payload %+v", payload)
+	}
+}
+
+func TestDeliverWebhookFailures(t *testing.T) {
+	redirected := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	})
+
+	receiver := httptest.NewServer(mux)
+	defer receiver.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"server error", "/error", http.StatusInternalServerError},
+		{"redirect", "/redirect", http.StatusTemporaryRedirect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt, succeeded := deliverWebhook(context.Background(), newWebhookClient(true), newTestDelivery(receiver.URL+tt.path), "")
+			if succeeded {
+				t.Fatal("delivery succeeded; want it to fail")
+			}
+			if attempt.ResponseStatus == nil || *attempt.ResponseStatus != tt.status {
+				t.Errorf("got response status %v; want %d", attempt.ResponseStatus, tt.status)
+			}
+			if attempt.Error == "" {
+				t.Error("got no error message")
+			}
+		})
+	}
+
+	if redirected {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestDeliverWebhookRefusesPrivateAddresses(t *testing.T) {
+	reached := false
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer receiver.Close()
+
+	// The receiver listens on 127.0.0.1, and also on localhost, which is only
+	// resolved to a loopback address when the connection is made.
+	_, port, _ := net.SplitHostPort(receiver.Listener.Addr().String())
+
+	for _, url := range []string{receiver.URL, "http://localhost:" + port} {
+		attempt, succeeded := deliverWebhook(context.Background(), newWebhookClient(false), newTestDelivery(url), "")
+		if succeeded {
+			t.Errorf("%s: delivery succeeded; want it refused", url)
+		}
+		if attempt.ResponseStatus != nil {
+			t.Errorf("%s: got response status %d; want no response", url, *attempt.ResponseStatus)
+		}
+		if !strings.Contains(attempt.Error, errPrivateWebhookAddress.Error()) {
+			t.Errorf("%s: got error %q; want it to mention %q", url, attempt.Error, errPrivateWebhookAddress)
+		}
+	}
+
+	if reached {
+		t.Error("receiver was reached")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("isPublicIP(%s) = %t; want %t", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestWebhookClientErrorWrapsErrPrivateWebhookAddress(t *testing.T) {
+	client := newWebhookClient(false)
+
+	_, err := client.Get("http://127.0.0.1:1/")
+	if !errors.Is(err, errPrivateWebhookAddress) {
+		t.Errorf("got error %v; want errPrivateWebhookAddress", err)
+	}
+}
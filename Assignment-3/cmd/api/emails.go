@@ -0,0 +1,192 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/jsonlog"
+	"assignment3.yerniyaz.net/internal/tracing"
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	emailPollInterval = 5 * time.Second
+	emailBatchSize    = 10
+	emailRetention    = 7 * 24 * time.Hour
+)
+
+// sendQueuedEmails runs until the done channel is closed, sending the emails in the
+// outbox that are due. Emails are sent one at a time, which keeps the load on the SMTP
+// server predictable; a failed email is retried later rather than holding up the rest.
+func (app *application) sendQueuedEmails(done <-chan struct{}) {
+	ticker := time.NewTicker(emailPollInterval)
+	defer ticker.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-prune.C:
+			err := app.models.Emails.DeleteSent(emailRetention)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			continue
+		case <-ticker.C:
+		}
+
+		emails, err := app.models.Emails.ClaimDue(emailBatchSize)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		for _, email := range emails {
+			app.sendQueuedEmail(email)
+		}
+	}
+}
+
+// sendQueuedEmail makes one attempt to send an email and records the outcome. The
+// attempt is traced as part of the trace of the request that queued the email, if
+// there was one.
+func (app *application) sendQueuedEmail(email *data.Email) {
+	parent, _ := tracing.ParseTraceparent(email.Traceparent)
+
+	ctx, span := app.tracer.StartRemote(context.Background(), parent, "send email", tracing.SpanKindClient,
+		tracing.Int64("email.id", email.ID),
+		tracing.String("email.template", email.Template),
+		tracing.Int("email.attempt", int(email.Attempts)+1),
+	)
+	defer span.End()
+
+	models := app.models.WithTrace(ctx)
+
+	err := app.mailer.Send(ctx, email.Recipient, email.Locale, email.Template, email.Data)
+	if err == nil {
+		err = models.Emails.MarkSent(email)
+	} else {
+		span.SetError(err)
+		// A failed send is retried, so it is only a warning until the email is
+		// dead-lettered.
+		app.logger.Warn("unable to send email",
+			jsonlog.Int64("email_id", email.ID),
+			jsonlog.Int("attempt", int(email.Attempts)+1),
+			jsonlog.Err(err),
+		)
+		err = models.Emails.RecordFailure(email, err, app.config.email.maxAttempts)
+	}
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// listEmailsHandler lets an admin inspect the email outbox, most commonly with
+// ?state=dead to find the emails that could not be delivered.
+func (app *application) listEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		State string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.State = app.readString(qs, "state", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+
+	input.Filters.SortSafelist = []string{"id", "attempts", "-id", "-attempts"}
+
+	v.Check(input.State == "" || validator.In(input.State, data.EmailPending, data.EmailSent, data.EmailDead), "state", validator.OneOf, "pending, sent, dead")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	emails, metadata, err := app.modelsFor(r).Emails.GetAll(input.State, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.modelsFor(r).Emails.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requeueEmailHandler puts a dead-lettered email back in the outbox, for example once
+// the SMTP problem that caused it to fail has been fixed.
+func (app *application) requeueEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.modelsFor(r).Emails.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if email.State != data.EmailDead {
+		app.emailNotDeadResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).Emails.Requeue(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"fmt"
+	"assignment3.yerniyaz.net/internal/jsonlog"
+	"assignment3.yerniyaz.net/internal/validator"
 	"net/http"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+	app.requestLogger(r).Error(err,
+		jsonlog.String("request_method", r.Method),
+		jsonlog.String("request_url", r.URL.String()),
+	)
 }
 
+// The errorResponse() method sends an error message to the client, along with the
+// request ID so that it can be matched up with the server's logs.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelope{"error": message}
+	env := envelope{"error": message, "request_id": app.contextGetRequestInfo(r).id}
 
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
@@ -24,17 +28,17 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
+	message := app.translate(r, "server_error")
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
+	message := app.translate(r, "not_found")
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := app.translate(r, "method_not_allowed", r.Method)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
@@ -42,40 +46,105 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// The failedValidationResponse() method sends the validation error messages in the
+// locale of the request, along with their error codes under the "error_codes" key so
+// that clients can handle them without depending on the wording.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	env := envelope{
+		"error":       v.Messages(app.requestLocale(r)),
+		"error_codes": v.Codes(),
+		"request_id":  app.contextGetRequestInfo(r).id,
+	}
+
+	err := app.writeJSON(w, http.StatusUnprocessableEntity, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
+	message := app.translate(r, "edit_conflict")
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "rate limit exceeded"
+	message := app.translate(r, "rate_limit_exceeded")
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// tooManyLoginAttemptsResponse is sent instead of checking the credentials while the
+// account or IP address is blocked by LoginFailureModel.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", ceilSeconds(retryAfter))
+	message := app.translate(r, "too_many_login_attempts")
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
+	message := app.translate(r, "invalid_credentials")
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidTwoFactorCodeResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "invalid_two_factor_code")
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// twoFactorLoginExpiredResponse is sent when the 2fa-pending token from the first step
+// of logging in is unknown or has expired, so the user has to start again.
+func (app *application) twoFactorLoginExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "two_factor_login_expired")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	message := "invalid or missing authentication token"
+	message := app.translate(r, "invalid_authentication_token")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this resource"
+	message := app.translate(r, "authentication_required")
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+func (app *application) deviceRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := app.translate(r, "device_required")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account must be activated to access this resource"
+	message := app.translate(r, "inactive_account")
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesn't have the necessary permissions to access this resource"
+	message := app.translate(r, "not_permitted")
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// twoFactorRequiredResponse is sent to users whose permissions require two-factor
+// authentication, when they haven't enabled it or try to disable it.
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "two_factor_required")
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) twoFactorEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "two_factor_enabled")
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) commandNotPendingResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "command_not_pending")
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) emailNotDeadResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "email_not_dead")
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "service_unavailable")
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
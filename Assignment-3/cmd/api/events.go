@@ -0,0 +1,373 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The most events replayed to a client that reconnects with a Last-Event-ID. A client
+// that has been away for longer than this should reload everything instead.
+const maxEventReplay = 1000
+
+// How far before a client's Last-Event-ID the replay reaches back, for events that
+// committed after later ones had been sent. Transactions are cut off long before this
+// by the queries' timeouts.
+const eventReplayOverlap = 30 * time.Second
+
+// How many recently sent event IDs each stream remembers, so that events read again
+// because of the overlap aren't sent twice.
+const recentEventsSize = 2 * maxEventReplay
+
+// How often a comment line is sent on an idle event stream, so that proxies and load
+// balancers don't close the connection.
+const eventHeartbeat = 15 * time.Second
+
+// eventPermissions maps each event resource to the permission a user needs to
+// receive events for it. Events for resources not listed here are never sent.
+var eventPermissions = map[string]string{
+	data.ResourceRemoteCar: "remote-cars:read",
+}
+
+// eventBroker fans the events received from PostgreSQL out to every open event
+// stream. Each subscriber has a small buffer; a subscriber that falls behind is
+// dropped, which ends its stream so that the client reconnects with its Last-Event-ID
+// and catches up from the database.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan *data.Event]struct{}
+	closed      bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[chan *data.Event]struct{})}
+}
+
+// subscribe returns a channel that receives every published event. It returns nil once
+// the broker has been closed.
+func (b *eventBroker) subscribe() chan *data.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	ch := make(chan *data.Event, 64)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan *data.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBroker) publish(event *data.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every open event stream. It is called when the server starts shutting
+// down, because Shutdown() would otherwise wait for the streams to finish on their
+// own.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	b.closed = true
+}
+
+// recentEvents is a bounded set of event IDs: once it is full, adding an ID forgets the
+// one that was added longest ago.
+type recentEvents struct {
+	ids   map[int64]struct{}
+	order []int64
+	next  int
+}
+
+func newRecentEvents(size int) *recentEvents {
+	return &recentEvents{ids: make(map[int64]struct{}, size), order: make([]int64, 0, size)}
+}
+
+// add adds the ID to the set, and reports whether it wasn't there already.
+func (s *recentEvents) add(id int64) bool {
+	if _, found := s.ids[id]; found {
+		return false
+	}
+
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, id)
+	} else {
+		delete(s.ids, s.order[s.next])
+		s.order[s.next] = id
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.ids[id] = struct{}{}
+
+	return true
+}
+
+// listenForNotifications receives the notifications sent by PostgreSQL on the events
+// and live channels and publishes them to the matching broker until the done channel
+// is closed. pq.Listener reconnects by itself if the database connection drops;
+// because notifications sent while it was disconnected are lost, it then reads any
+// events it missed from the events table. Some of those will have been published
+// already, and are skipped by the event streams. Missed live updates aren't recovered, as
+// they are superseded by the next ones anyway.
+func (app *application) listenForNotifications(done <-chan struct{}) {
+	lastID, err := app.models.Events.LatestID()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"component": "listener"})
+		}
+	})
+
+	go func() {
+		<-done
+		listener.Close()
+	}()
+
+	for _, channel := range []string{data.EventsChannel, data.LiveChannel} {
+		err = listener.Listen(channel)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"channel": channel})
+			return
+		}
+	}
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case notification, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+
+			// A nil notification means that the connection was re-established.
+			if notification == nil {
+				events, err := app.models.Events.GetAfter(lastID, eventReplayOverlap, maxEventReplay)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+					continue
+				}
+				for _, event := range events {
+					app.events.publish(event)
+					if event.ID > lastID {
+						lastID = event.ID
+					}
+				}
+				continue
+			}
+
+			switch notification.Channel {
+			case data.EventsChannel:
+				var event data.Event
+
+				err := json.Unmarshal([]byte(notification.Extra), &event)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"channel": notification.Channel})
+					continue
+				}
+
+				app.events.publish(&event)
+				if event.ID > lastID {
+					lastID = event.ID
+				}
+
+			case data.LiveChannel:
+				var update data.LiveUpdate
+
+				err := json.Unmarshal([]byte(notification.Extra), &update)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"channel": notification.Channel})
+					continue
+				}
+
+				app.live.publish(&update)
+			}
+
+		case <-prune.C:
+			err := app.models.Events.DeleteExpired()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+// eventsHandler streams create, update and delete events as server-sent events. Each
+// event is named after its resource and action (such as "remote_car.updated") and
+// carries its ID, so a client that reconnects with the standard Last-Event-ID header
+// (or the last_event_id query string parameter) is sent the events it missed first.
+// Users only receive events for resources they have permission to read.
+//
+// Events are sent in the order they commit, which isn't always the order of their
+// IDs. The replay after a reconnect reaches back eventReplayOverlap before the
+// Last-Event-ID to catch events that committed late, so delivery is at least once: a
+// client may be sent an event again after reconnecting, and should ignore IDs it has
+// already seen.
+//
+// TODO: only remote car events are streamed. Booking events need a bookings table and
+// its triggers.
+func (app *application) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	allowed := make(map[string]bool)
+	for resource, code := range eventPermissions {
+		allowed[resource] = permissions.Include(code)
+	}
+
+	if !allowed[data.ResourceRemoteCar] {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if !app.checkTwoFactorRequirement(w, r, user.ID, permissions) {
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastID int64
+	if lastEventID != "" {
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastID < 0 {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid Last-Event-ID %q", lastEventID))
+			return
+		}
+	}
+
+	// Subscribe before reading the backlog, so that nothing published in between is
+	// missed. Events that were already sent as part of the backlog, or that are
+	// published twice after the listener reconnects, are skipped using their IDs.
+	events := app.events.subscribe()
+	if events == nil {
+		return
+	}
+	defer app.events.unsubscribe(events)
+
+	var backlog []*data.Event
+	if lastID > 0 {
+		backlog, err = app.modelsFor(r).Events.GetAfter(lastID, eventReplayOverlap, maxEventReplay)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The stream is expected to stay open for much longer than the server's
+	// WriteTimeout, so remove the deadline for this response.
+	rc := http.NewResponseController(w)
+
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	send := func(event *data.Event) error {
+		if !allowed[event.Resource] {
+			return nil
+		}
+
+		js, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s.%s\ndata: %s\n\n", event.ID, event.Resource, event.Action, js)
+		return err
+	}
+
+	sent := newRecentEvents(recentEventsSize)
+
+	for _, event := range backlog {
+		sent.add(event.ID)
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !sent.add(event.ID) {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
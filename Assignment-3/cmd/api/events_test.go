@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestRecentEvents(t *testing.T) {
+	s := newRecentEvents(3)
+
+	for _, id := range []int64{5, 3, 4} {
+		if !s.add(id) {
+			t.Errorf("add(%d) = false on first add; want true", id)
+		}
+	}
+	if s.add(3) {
+		t.Error("add(3) = true on second add; want false")
+	}
+
+	// Adding a fourth ID forgets the first one added, whatever the order of the IDs.
+	if !s.add(6) {
+		t.Error("add(6) = false on first add; want true")
+	}
+	if !s.add(5) {
+		t.Error("add(5) = false after it was evicted; want true")
+	}
+	for _, id := range []int64{4, 6, 5} {
+		if s.add(id) {
+			t.Errorf("add(%d) = true while still held; want false", id)
+		}
+	}
+	if len(s.ids) != 3 {
+		t.Errorf("holds %d IDs; want 3", len(s.ids))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/validator"
+	"errors"
+	"net/http"
+)
+
+func (app *application) addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	carID, err := app.readInt64Param(r, "car_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(carID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.modelsFor(r).Favorites.Add(user.ID, carID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "remote_car added to favorites"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	carID, err := app.readInt64Param(r, "car_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.modelsFor(r).Favorites.Remove(user.ID, carID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "remote_car removed from favorites"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	input.Filters.SortSafelist = remoteCarsSortSafelist
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	remotecars, metadata, err := app.modelsFor(r).Favorites.GetAllForUser(user.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	isFavorite := true
+	for _, remotecar := range remotecars {
+		remotecar.IsFavorite = &isFavorite
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"remotecars": remotecars, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// markFavorites sets the IsFavorite flag on the given remote cars for the user in the
+// request context. Anonymous users have no favorites, so the flag is left as nil and
+// is omitted from the JSON response entirely.
+func (app *application) markFavorites(r *http.Request, remotecars ...*data.RemoteCars) error {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return nil
+	}
+
+	ids := make([]int64, len(remotecars))
+	for i := range remotecars {
+		ids[i] = remotecars[i].ID
+	}
+
+	favorited, err := app.modelsFor(r).Favorites.Favorited(user.ID, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, remotecar := range remotecars {
+		isFavorite := favorited[remotecar.ID]
+		remotecar.IsFavorite = &isFavorite
+	}
+
+	return nil
+}
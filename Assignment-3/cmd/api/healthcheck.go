@@ -1,10 +1,31 @@
 package main
 
 import (
+	"assignment3.yerniyaz.net/internal/jsonlog"
+	"context"
 	"net/http"
+	"sync"
+	"time"
 )
 
-func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+const (
+	readyzPingTimeout = 2 * time.Second
+	// Checking the SMTP server means opening a connection to it, so the result is
+	// reused for a while rather than repeated on every probe.
+	mailerCheckInterval = 30 * time.Second
+)
+
+// mailerHealth caches the result of the last mailer check.
+type mailerHealth struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// healthzHandler is the liveness check: it only says that the process is up and able
+// to handle requests, and never looks at anything it depends on. Restarting the API
+// wouldn't fix Postgres being down.
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
 		"status": "available",
 		"system_info": map[string]string{
@@ -17,3 +38,86 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readyzHandler is the readiness check, which load balancers use to decide whether to
+// send us traffic. We are ready if Postgres answers a ping in time and shutdown hasn't
+// begun; otherwise it responds with 503 Service Unavailable. The mailer is checked
+// too, but as emails wait in the outbox until they can be sent, a mailer problem is
+// reported without making us unready.
+//
+// Errors are logged rather than included in the response, which anyone can see.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ready := true
+
+	database := map[string]interface{}{"status": "ok"}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyzPingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := app.db.PingContext(ctx)
+	database["latency"] = time.Since(start).String()
+	if err != nil {
+		app.requestLogger(r).Warn("readiness check: database unavailable", jsonlog.Err(err))
+		database["status"] = "unavailable"
+		ready = false
+	}
+
+	stats := app.db.Stats()
+	database["pool"] = map[string]interface{}{
+		"max_open":      stats.MaxOpenConnections,
+		"open":          stats.OpenConnections,
+		"in_use":        stats.InUse,
+		"idle":          stats.Idle,
+		"wait_count":    stats.WaitCount,
+		"wait_duration": stats.WaitDuration.String(),
+	}
+
+	mail := map[string]interface{}{"status": "ok", "backend": app.config.mailer.backend}
+
+	err = app.checkMailer()
+	if err != nil {
+		app.requestLogger(r).Warn("readiness check: mailer unavailable", jsonlog.Err(err))
+		mail["status"] = "unavailable"
+	}
+
+	status := "ready"
+	if app.shuttingDown.Load() {
+		status = "shutting_down"
+		ready = false
+	} else if !ready {
+		status = "not_ready"
+	}
+
+	env := envelope{
+		"status": status,
+		"checks": map[string]interface{}{
+			"database": database,
+			"mailer":   mail,
+		},
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	err = app.writeJSON(w, code, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkMailer returns the result of the last mailer check, checking again first if it
+// is out of date. Only one check runs at a time; other probes wait for it.
+func (app *application) checkMailer() error {
+	app.mailerHealth.mu.Lock()
+	defer app.mailerHealth.mu.Unlock()
+
+	if time.Since(app.mailerHealth.checkedAt) >= mailerCheckInterval {
+		app.mailerHealth.err = app.mailer.Check()
+		app.mailerHealth.checkedAt = time.Now()
+	}
+
+	return app.mailerHealth.err
+}
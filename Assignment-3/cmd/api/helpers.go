@@ -1,25 +1,34 @@
 package main
 
 import (
+	"assignment3.yerniyaz.net/internal/data"
 	"assignment3.yerniyaz.net/internal/validator"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type envelope map[string]interface{}
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// readInt64Param reads a positive integer URL parameter with the given name, such as
+// the :car_id in /v1/users/me/favorites/:car_id.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -93,6 +102,23 @@ func (app *application) readString(qs url.Values, key string, defaultValue strin
 	return s
 }
 
+// readCSV reads a comma-separated query string value into a slice, ignoring empty
+// entries. Note that the default is returned as-is when the key isn't present.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+
+	values := []string{}
+	for _, value := range strings.Split(csv, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
 	s := qs.Get(key)
 	if s == "" {
@@ -101,19 +127,97 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, validator.Integer)
 		return defaultValue
 	}
 	return i
 }
 
+// readFloat reads a decimal number from the query string.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		v.AddError(key, validator.Number)
+		return defaultValue
+	}
+	return f
+}
+
+// readLocation reads a point given as "latitude,longitude" from the query string. It
+// returns nil if the key isn't present or the value can't be parsed; the range of the
+// coordinates is left to data.ValidateLocation().
+func (app *application) readLocation(qs url.Values, key string, v *validator.Validator) *data.Location {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		v.AddError(key, validator.LatLng)
+		return nil
+	}
+
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || math.IsNaN(latitude) {
+		v.AddError(key, validator.LatLng)
+		return nil
+	}
+
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || math.IsNaN(longitude) {
+		v.AddError(key, validator.LatLng)
+		return nil
+	}
+
+	return &data.Location{Latitude: latitude, Longitude: longitude}
+}
+
+// readTime reads an RFC 3339 timestamp from the query string, recording a validation
+// error and returning the default if it can't be parsed.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, validator.Timestamp)
+		return defaultValue
+	}
+	return t
+}
+
+// readDuration reads a Go duration string such as "30s" or "5m" from the query string.
+func (app *application) readDuration(qs url.Values, key string, defaultValue time.Duration, v *validator.Validator) time.Duration {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		v.AddError(key, validator.Duration)
+		return defaultValue
+	}
+	return d
+}
+
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter.
 	app.wg.Add(1)
+	app.serverMetrics.backgroundTasks.Add(1)
 	// Launch the background goroutine.
 	go func() {
 		// Use defer to decrement the WaitGroup counter before the goroutine returns.
 		defer app.wg.Done()
+		defer app.serverMetrics.backgroundTasks.Add(-1)
 		defer func() {
 			if err := recover(); err != nil {
 				app.logger.PrintError(fmt.Errorf("%s", err), nil)
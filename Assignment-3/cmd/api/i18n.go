@@ -0,0 +1,126 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/i18n"
+	"net/http"
+)
+
+// messages holds the translations of the error messages sent by the helpers in
+// errors.go. Validation messages are translated by the validator package.
+var messages = i18n.Catalog{
+	"server_error": {
+		i18n.English: "the server encountered a problem and could not process your request",
+		i18n.Russian: "на сервере возникла проблема, и он не смог обработать ваш запрос",
+		i18n.Kazakh:  "серверде ақау пайда болды және ол сұрауыңызды өңдей алмады",
+	},
+	"not_found": {
+		i18n.English: "the requested resource could not be found",
+		i18n.Russian: "запрошенный ресурс не найден",
+		i18n.Kazakh:  "сұралған ресурс табылмады",
+	},
+	"method_not_allowed": {
+		i18n.English: "the %s method is not supported for this resource",
+		i18n.Russian: "метод %s не поддерживается для этого ресурса",
+		i18n.Kazakh:  "бұл ресурс үшін %s әдісіне қолдау көрсетілмейді",
+	},
+	"edit_conflict": {
+		i18n.English: "unable to update the record due to an edit conflict, please try again",
+		i18n.Russian: "не удалось обновить запись из-за конфликта изменений, попробуйте ещё раз",
+		i18n.Kazakh:  "өзгерістер қақтығысына байланысты жазбаны жаңарту мүмкін болмады, қайталап көріңіз",
+	},
+	"rate_limit_exceeded": {
+		i18n.English: "rate limit exceeded",
+		i18n.Russian: "превышен лимит запросов",
+		i18n.Kazakh:  "сұраулар шегінен асып кетті",
+	},
+	"too_many_login_attempts": {
+		i18n.English: "too many failed login attempts, please try again later",
+		i18n.Russian: "слишком много неудачных попыток входа, повторите попытку позже",
+		i18n.Kazakh:  "кіру әрекеттері тым көп рет сәтсіз болды, кейінірек қайталап көріңіз",
+	},
+	"invalid_credentials": {
+		i18n.English: "invalid authentication credentials",
+		i18n.Russian: "неверные учётные данные",
+		i18n.Kazakh:  "тіркелгі деректері қате",
+	},
+	"invalid_two_factor_code": {
+		i18n.English: "invalid or already used two-factor authentication code",
+		i18n.Russian: "неверный или уже использованный код двухфакторной аутентификации",
+		i18n.Kazakh:  "екі факторлы аутентификация коды қате немесе бұрын қолданылған",
+	},
+	"two_factor_login_expired": {
+		i18n.English: "the login has expired, please log in with your password again",
+		i18n.Russian: "срок входа истёк, войдите с паролем ещё раз",
+		i18n.Kazakh:  "кіру мерзімі өтті, құпиясөзбен қайта кіріңіз",
+	},
+	"two_factor_required": {
+		i18n.English: "your account's permissions require two-factor authentication to be enabled",
+		i18n.Russian: "права вашей учётной записи требуют включённой двухфакторной аутентификации",
+		i18n.Kazakh:  "тіркелгіңіздің рұқсаттары екі факторлы аутентификацияның қосулы болуын талап етеді",
+	},
+	"two_factor_enabled": {
+		i18n.English: "two-factor authentication is already enabled",
+		i18n.Russian: "двухфакторная аутентификация уже включена",
+		i18n.Kazakh:  "екі факторлы аутентификация қосылып қойған",
+	},
+	"invalid_authentication_token": {
+		i18n.English: "invalid or missing authentication token",
+		i18n.Russian: "токен аутентификации недействителен или отсутствует",
+		i18n.Kazakh:  "аутентификация токені жарамсыз немесе жоқ",
+	},
+	"authentication_required": {
+		i18n.English: "you must be authenticated to access this resource",
+		i18n.Russian: "для доступа к этому ресурсу необходимо войти в систему",
+		i18n.Kazakh:  "бұл ресурсқа кіру үшін аутентификациядан өту қажет",
+	},
+	"device_required": {
+		i18n.English: "you must authenticate with a device key to access this resource",
+		i18n.Russian: "для доступа к этому ресурсу необходимо пройти аутентификацию с ключом устройства",
+		i18n.Kazakh:  "бұл ресурсқа кіру үшін құрылғы кілтімен аутентификациядан өту қажет",
+	},
+	"inactive_account": {
+		i18n.English: "your user account must be activated to access this resource",
+		i18n.Russian: "для доступа к этому ресурсу ваша учётная запись должна быть активирована",
+		i18n.Kazakh:  "бұл ресурсқа кіру үшін тіркелгіңіз белсендірілген болуы керек",
+	},
+	"not_permitted": {
+		i18n.English: "your user account doesn't have the necessary permissions to access this resource",
+		i18n.Russian: "у вашей учётной записи нет прав для доступа к этому ресурсу",
+		i18n.Kazakh:  "тіркелгіңізде бұл ресурсқа кіруге қажетті рұқсаттар жоқ",
+	},
+	"command_not_pending": {
+		i18n.English: "the command has already been completed or has expired",
+		i18n.Russian: "команда уже выполнена или её срок истёк",
+		i18n.Kazakh:  "команда орындалып қойған немесе оның мерзімі өткен",
+	},
+	"email_not_dead": {
+		i18n.English: "only dead-lettered emails can be requeued",
+		i18n.Russian: "повторно поставить в очередь можно только недоставленные письма",
+		i18n.Kazakh:  "кезекке тек жеткізілмеген хаттарды қайта қоюға болады",
+	},
+	"service_unavailable": {
+		i18n.English: "the server is shutting down, please try again shortly",
+		i18n.Russian: "сервер завершает работу, повторите попытку чуть позже",
+		i18n.Kazakh:  "сервер жұмысын аяқтап жатыр, сәл кейінірек қайталап көріңіз",
+	},
+}
+
+// requestLocale returns the locale to respond to a request in. Authenticated users get
+// the locale saved on their account; anyone else gets the best match for their
+// Accept-Language header. Unlike contextGetUser() this doesn't panic when there's no
+// user in the context, because error responses can be sent before authenticate() has
+// run.
+func (app *application) requestLocale(r *http.Request) string {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if ok && !user.IsAnonymous() && i18n.Supported(user.Locale) {
+		return user.Locale
+	}
+
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// translate returns the message with the given code in the locale of the request.
+func (app *application) translate(r *http.Request, code string, args ...interface{}) string {
+	return messages.Translate(app.requestLocale(r), code, args...)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/validator"
+	"assignment3.yerniyaz.net/internal/websocket"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timings for the live WebSocket. The server pings the client every livePingPeriod,
+// and a client that sends nothing at all (not even a pong) for liveReadTimeout is
+// disconnected.
+const (
+	livePingPeriod  = 30 * time.Second
+	liveReadTimeout = 2 * livePingPeriod
+	liveCloseWait   = time.Second
+)
+
+// The types of the messages sent on the live WebSocket. Telemetry and command updates
+// use data.LiveTelemetry and data.LiveCommand.
+const (
+	liveCommandQueued = "command_queued"
+	liveError         = "error"
+)
+
+// liveMessage is a message sent to the client on the live WebSocket. Updates from the
+// database are sent as they are; replies to control messages echo the client's
+// request_id.
+type liveMessage struct {
+	Type       string            `json:"type"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Command    *data.Command     `json:"command,omitempty"`
+	Error      interface{}       `json:"error,omitempty"`
+	ErrorCodes map[string]string `json:"error_codes,omitempty"`
+}
+
+// liveControlMessage is a message received from the client. The only supported type is
+// "command", which queues a command for the car exactly like a POST to
+// /v1/remote-cars/:id/commands.
+type liveControlMessage struct {
+	Type      string       `json:"type"`
+	RequestID string       `json:"request_id"`
+	Command   commandInput `json:"command"`
+}
+
+// liveBroker fans live updates out to the WebSocket sessions watching each remote car.
+// Unlike the /v1/events streams, the sessions are hijacked connections that
+// http.Server.Shutdown() doesn't know about, so the broker also keeps track of them
+// and close() waits for every session to say goodbye to its client.
+type liveBroker struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[chan *data.LiveUpdate]struct{}
+	closed      bool
+	sessions    sync.WaitGroup
+}
+
+func newLiveBroker() *liveBroker {
+	return &liveBroker{subscribers: make(map[int64]map[chan *data.LiveUpdate]struct{})}
+}
+
+// subscribe registers a session for a remote car's updates. It returns nil once the
+// broker has been closed. Every successful call must be paired with unsubscribe().
+func (b *liveBroker) subscribe(remoteCarID int64) chan *data.LiveUpdate {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	if b.subscribers[remoteCarID] == nil {
+		b.subscribers[remoteCarID] = make(map[chan *data.LiveUpdate]struct{})
+	}
+
+	ch := make(chan *data.LiveUpdate, 64)
+	b.subscribers[remoteCarID][ch] = struct{}{}
+	b.sessions.Add(1)
+	return ch
+}
+
+func (b *liveBroker) unsubscribe(remoteCarID int64, ch chan *data.LiveUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(remoteCarID, ch)
+	b.sessions.Done()
+}
+
+// remove deletes and closes a subscriber channel if it is still registered. The
+// caller must hold mu.
+func (b *liveBroker) remove(remoteCarID int64, ch chan *data.LiveUpdate) {
+	if _, ok := b.subscribers[remoteCarID][ch]; !ok {
+		return
+	}
+
+	delete(b.subscribers[remoteCarID], ch)
+	if len(b.subscribers[remoteCarID]) == 0 {
+		delete(b.subscribers, remoteCarID)
+	}
+	close(ch)
+}
+
+// publish sends an update to every session watching the car. A session that has
+// fallen too far behind is dropped, and its client has to reconnect.
+func (b *liveBroker) publish(update *data.LiveUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[update.RemoteCarID] {
+		select {
+		case ch <- update:
+		default:
+			b.remove(update.RemoteCarID, ch)
+		}
+	}
+}
+
+// close ends every live session and blocks until they have all finished their closing
+// handshakes.
+func (b *liveBroker) close() {
+	b.mu.Lock()
+	for remoteCarID, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			b.remove(remoteCarID, ch)
+		}
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	b.sessions.Wait()
+}
+
+// liveRemoteCarHandler upgrades the request to a WebSocket that pushes the car's
+// telemetry and command state changes as they happen, and accepts control messages
+// for queueing commands. Authentication uses the normal Authorization header, which is
+// checked by the middleware before the upgrade.
+func (app *application) liveRemoteCarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	updates := app.live.subscribe(id)
+	if updates == nil {
+		app.serviceUnavailableResponse(w, r)
+		return
+	}
+	defer app.live.unsubscribe(id, updates)
+
+	conn, err := websocket.Upgrade(w, r, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, websocket.ErrBadHandshake):
+			app.badRequestResponse(w, r, errors.New("this endpoint requires a WebSocket connection"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(4096)
+	conn.SetReadTimeout(liveReadTimeout)
+
+	// Messages are read on a separate goroutine, which stops as soon as the connection
+	// is closed, the client breaks the protocol or this handler returns.
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+
+	user := app.contextGetUser(r)
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				// Either the server is shutting down or this client has fallen behind.
+				// Start the closing handshake and give the client a moment to reply.
+				conn.WriteClose(websocket.CloseGoingAway, "reconnect to continue")
+				select {
+				case <-readErr:
+				case <-time.After(liveCloseWait):
+				}
+				return
+			}
+			err = conn.WriteJSON(update)
+
+		case message := <-messages:
+			err = conn.WriteJSON(app.handleLiveControl(id, user, message))
+
+		case err = <-readErr:
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				app.logError(r, err)
+			}
+			return
+
+		case <-ping.C:
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		}
+
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+}
+
+// handleLiveControl carries out a control message from a live WebSocket client and
+// returns the reply. Commands go through the same permission check and validation as
+// the REST API; the permissions are looked up again for every message, so revoking
+// remote-cars:control takes effect without the client having to reconnect.
+func (app *application) handleLiveControl(remoteCarID int64, user *data.User, message []byte) *liveMessage {
+	var control liveControlMessage
+
+	err := json.Unmarshal(message, &control)
+	if err != nil {
+		return &liveMessage{Type: liveError, Error: "message must be a JSON object"}
+	}
+
+	reply := &liveMessage{Type: liveError, RequestID: control.RequestID}
+
+	if control.Type != "command" {
+		reply.Error = "type must be command"
+		return reply
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		reply.Error = messages.Translate(user.Locale, "server_error")
+		return reply
+	}
+
+	if !permissions.Include("remote-cars:control") {
+		reply.Error = messages.Translate(user.Locale, "not_permitted")
+		return reply
+	}
+
+	v := validator.New()
+
+	command := app.newCommand(remoteCarID, user, control.Command, v)
+	if !v.Valid() {
+		reply.Error = v.Messages(user.Locale)
+		reply.ErrorCodes = v.Codes()
+		return reply
+	}
+
+	err = app.models.Commands.Insert(command)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		reply.Error = messages.Translate(user.Locale, "server_error")
+		return reply
+	}
+
+	reply.Type = liveCommandQueued
+	reply.Command = command
+	return reply
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"assignment3.yerniyaz.net/internal/data"
 	"assignment3.yerniyaz.net/internal/jsonlog"
+	"assignment3.yerniyaz.net/internal/limiter"
 	"assignment3.yerniyaz.net/internal/mailer"
+	"assignment3.yerniyaz.net/internal/tracing"
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	_ "github.com/lib/pq"
+	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,10 +31,29 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	// The limiter store holds the rate limiter's buckets: "memory" keeps them in the
+	// process and "postgres" in the database, where they are shared by every instance
+	// of the API. Anonymous clients are limited by IP address and authenticated users
+	// by user ID, with higher limits for the tiers given to some permissions. The auth
+	// limit is an extra, stricter one for logging in and signing up. The credentials
+	// limit applies per IP address to every request that sends a token or device key,
+	// before it is looked up, so it should be higher than any of the tiers.
 	limiter struct {
-		enabled bool
-		rps     float64
-		burst   int
+		enabled     bool
+		anonymous   limiter.Limit
+		credentials limiter.Limit
+		user        limiter.Limit
+		auth        limiter.Limit
+		tiers       []rateLimitTier
+		store       string
+	}
+	// The mailer backend decides what happens to outgoing emails: "smtp" sends them,
+	// "file" writes them as .eml files to the dir directory, and "log" notes them in
+	// the application log without their contents. As "log" marks emails sent while
+	// losing them, it is only allowed in the development environment.
+	mailer struct {
+		backend string
+		dir     string
 	}
 	smtp struct {
 		host     string
@@ -38,25 +62,81 @@ type config struct {
 		password string
 		sender   string
 	}
+	email struct {
+		maxAttempts int
+	}
+	// Webhooks are only delivered to public addresses, unless allowPrivate is set so
+	// that they can be tried out against a receiver on the developer's machine.
+	webhooks struct {
+		allowPrivate bool
+	}
+	// The metrics are served on a separate listener if addr is set. Otherwise they are
+	// served by the API itself, behind basic authentication, but only when a password
+	// has been set.
+	metrics struct {
+		addr     string
+		username string
+		password string
+	}
+	// Spans are exported as OTLP/JSON, either appended to a file or posted to the
+	// OTLP/HTTP endpoint of a collector, depending on the exporter. Tracing is off
+	// unless an exporter is chosen.
+	tracing struct {
+		exporter string
+		file     string
+		endpoint string
+	}
+	// Stack traces are off by default, since the request ID is usually enough to find
+	// out what went wrong; the text format is meant for local development.
+	log struct {
+		level       string
+		format      string
+		stackTraces bool
+	}
+	// Users holding any of these permissions have to enable two-factor authentication
+	// before they can use any permission, and can't turn it off.
+	twoFactor struct {
+		requiredPermissions []string
+	}
 	// Add a cors struct and trustedOrigins field with the type []string.
 	cors struct {
 		trustedOrigins []string
 	}
+	// Requests from the trusted proxies have their client IP address taken from the
+	// header the proxies set, either Forwarded or X-Forwarded-For. Only that header is
+	// read, since the proxies pass the other one on from the client unchanged.
+	trustedProxies     []*net.IPNet
+	trustedProxyHeader string
+	// When shutdown begins, /v1/readyz starts failing straight away, but the server
+	// keeps handling requests for the drain delay, so that load balancers notice and
+	// stop sending us new ones before the server shuts down.
+	shutdown struct {
+		drainDelay time.Duration
+	}
 }
 
 // Update the application struct to hold a new Mailer instance.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config        config
+	logger        *jsonlog.Logger
+	db            *sql.DB
+	models        data.Models
+	mailer        mailer.Mailer
+	limiter       limiter.Store
+	events        *eventBroker
+	live          *liveBroker
+	serverMetrics *serverMetrics
+	tracer        *tracing.Tracer
+	mailerHealth  mailerHealth
+	shuttingDown  atomic.Bool
+	wg            sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.DurationVar(&cfg.shutdown.drainDelay, "shutdown-drain-delay", 5*time.Second, "How long to keep serving after /v1/readyz starts failing on shutdown")
 	fmt.Println(os.Getenv("GREENLIGHT_DB_DSN"))
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
 
@@ -64,23 +144,89 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.anonymous.Rate, "limiter-rps", 2, "Rate limiter maximum requests per second for anonymous clients, per IP address")
+	flag.IntVar(&cfg.limiter.anonymous.Burst, "limiter-burst", 4, "Rate limiter maximum burst for anonymous clients")
+	flag.Float64Var(&cfg.limiter.credentials.Rate, "limiter-credentials-rps", 20, "Rate limiter maximum requests per second with a token or device key, per IP address, checked before authentication")
+	flag.IntVar(&cfg.limiter.credentials.Burst, "limiter-credentials-burst", 40, "Rate limiter maximum burst for requests with a token or device key")
+	flag.Float64Var(&cfg.limiter.user.Rate, "limiter-user-rps", 5, "Rate limiter maximum requests per second for authenticated users and devices")
+	flag.IntVar(&cfg.limiter.user.Burst, "limiter-user-burst", 10, "Rate limiter maximum burst for authenticated users and devices")
+	flag.Float64Var(&cfg.limiter.auth.Rate, "limiter-auth-rps", 0.2, "Rate limiter maximum requests per second for logging in and signing up, per IP address")
+	flag.IntVar(&cfg.limiter.auth.Burst, "limiter-auth-burst", 5, "Rate limiter maximum burst for logging in and signing up")
+	flag.Func("limiter-tiers", "Rate limits for users with a permission (space separated permission=rps:burst)", func(val string) error {
+		tiers, err := parseRateLimitTiers(val)
+		cfg.limiter.tiers = tiers
+		return err
+	})
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limiter store (memory|postgres)")
+
+	flag.StringVar(&cfg.mailer.backend, "mailer", "smtp", "Mailer backend (smtp|file|log); log is for development only")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "./tmp/emails", "Directory for .eml files written by the file mailer")
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	// The SMTP credentials have no defaults; they are read from the environment, like
+	// the DSN, or passed in with the flags.
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "3f1db0868a9e3b", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "58fea132f39fca", "SMTP password")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Remote cars <no-reply@remotecars.yerniaz.net>", "SMTP sender")
 
+	flag.IntVar(&cfg.email.maxAttempts, "email-max-attempts", 8, "Attempts to send an email before it is dead-lettered")
+
+	flag.BoolVar(&cfg.webhooks.allowPrivate, "webhooks-allow-private", false, "Allow webhooks to be delivered to loopback and private addresses (for development)")
+
+	flag.StringVar(&cfg.metrics.addr, "metrics-addr", "", "Separate listen address for /debug/metrics, such as localhost:4001")
+	flag.StringVar(&cfg.metrics.username, "metrics-username", "metrics", "Basic auth username for /debug/metrics")
+	flag.StringVar(&cfg.metrics.password, "metrics-password", os.Getenv("METRICS_PASSWORD"), "Basic auth password for /debug/metrics")
+
+	flag.StringVar(&cfg.tracing.exporter, "tracing", "none", "Trace exporter (none|file|otlp)")
+	flag.StringVar(&cfg.tracing.file, "tracing-file", "./tmp/traces.jsonl", "File that the file trace exporter appends OTLP/JSON to")
+	flag.StringVar(&cfg.tracing.endpoint, "tracing-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint for the otlp trace exporter")
+
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug|info|warn|error|fatal|off)")
+	flag.StringVar(&cfg.log.format, "log-format", "json", "Log format (json|text)")
+	flag.BoolVar(&cfg.log.stackTraces, "log-stack-traces", false, "Include stack traces in error log entries")
+
+	flag.Func("2fa-required-permissions", "Permissions whose holders must enable two-factor authentication (space separated)", func(val string) error {
+		cfg.twoFactor.requiredPermissions = strings.Fields(val)
+		return nil
+	})
+
+	flag.Func("trusted-proxies", "Trusted proxy CIDR ranges, whose -trusted-proxy-header is used (space or comma separated)", func(val string) error {
+		proxies, err := parseTrustedProxies(val)
+		cfg.trustedProxies = proxies
+		return err
+	})
+	cfg.trustedProxyHeader = "x-forwarded-for"
+	flag.Func("trusted-proxy-header", "Header the trusted proxies record the client IP address in (forwarded|x-forwarded-for) (default \"x-forwarded-for\")", func(val string) error {
+		if val != "forwarded" && val != "x-forwarded-for" {
+			return fmt.Errorf("invalid trusted proxy header %q (must be forwarded or x-forwarded-for)", val)
+		}
+		cfg.trustedProxyHeader = val
+		return nil
+	})
+
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
 	flag.Parse()
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	logger, err := newLogger(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	mail, err := newMailer(cfg, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	tracer, err := newTracer(cfg, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
 	//db, err := sql.Open("postgres", "user=yerniaz password=1234 dbname=greenlight sslmode=disable")
 
@@ -93,11 +239,22 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	limiterStore, err := newLimiterStore(cfg, db)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:        cfg,
+		logger:        logger,
+		db:            db,
+		models:        data.NewModels(db),
+		mailer:        mail,
+		limiter:       limiterStore,
+		events:        newEventBroker(),
+		live:          newLiveBroker(),
+		serverMetrics: newServerMetrics(db),
+		tracer:        tracer,
 	}
 	err = app.serve()
 	if err != nil {
@@ -106,6 +263,87 @@ func main() {
 
 }
 
+// newLogger returns a logger configured by the -log-level, -log-format and
+// -log-stack-traces flags.
+func newLogger(cfg config) (*jsonlog.Logger, error) {
+	level, err := jsonlog.ParseLevel(cfg.log.level)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := jsonlog.ParseFormat(cfg.log.format)
+	if err != nil {
+		return nil, err
+	}
+
+	options := jsonlog.Options{
+		Format:      format,
+		StackTraces: cfg.log.stackTraces,
+	}
+
+	return jsonlog.NewWithOptions(os.Stdout, level, options), nil
+}
+
+// newTracer returns a tracer using the exporter chosen with the -tracing flag, or nil
+// if tracing is off.
+func newTracer(cfg config, logger *jsonlog.Logger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+
+	switch cfg.tracing.exporter {
+	case "none":
+		return nil, nil
+	case "file":
+		file, err := tracing.NewFile(cfg.tracing.file)
+		if err != nil {
+			return nil, err
+		}
+		exporter = file
+	case "otlp":
+		exporter = tracing.NewHTTP(cfg.tracing.endpoint)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (must be none, file or otlp)", cfg.tracing.exporter)
+	}
+
+	options := tracing.Options{
+		ServiceName:    "remote-cars-api",
+		ServiceVersion: version,
+		ErrorLog: func(err error) {
+			logger.Warn("unable to export spans", jsonlog.Err(err))
+		},
+	}
+
+	return tracing.New(exporter, options), nil
+}
+
+// newMailer returns the mailer backend chosen with the -mailer flag.
+func newMailer(cfg config, logger *jsonlog.Logger) (mailer.Mailer, error) {
+	switch cfg.mailer.backend {
+	case "smtp":
+		return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender), nil
+	case "file":
+		return mailer.NewFile(cfg.mailer.dir, cfg.smtp.sender)
+	case "log":
+		if cfg.env != "development" {
+			return nil, errors.New("the log mailer discards emails, so it can only be used with -env=development")
+		}
+		return mailer.NewLog(logger, cfg.smtp.sender), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q (must be smtp, file or log)", cfg.mailer.backend)
+	}
+}
+
+// newLimiterStore returns the rate limiter store chosen with the -limiter-store flag.
+func newLimiterStore(cfg config, db *sql.DB) (limiter.Store, error) {
+	switch cfg.limiter.store {
+	case "memory":
+		return limiter.NewMemory(), nil
+	case "postgres":
+		return limiter.NewPostgres(db), nil
+	default:
+		return nil, fmt.Errorf("unknown limiter store %q (must be memory or postgres)", cfg.limiter.store)
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 
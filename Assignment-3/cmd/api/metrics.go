@@ -0,0 +1,160 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/metrics"
+	"assignment3.yerniyaz.net/internal/tracing"
+	"crypto/subtle"
+	"database/sql"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// serverMetrics holds the metrics served at /debug/metrics.
+type serverMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	duration        *metrics.Histogram
+	rateLimited     *metrics.Counter
+	inFlight        atomic.Int64
+	backgroundTasks atomic.Int64
+}
+
+func newServerMetrics(db *sql.DB) *serverMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &serverMetrics{
+		registry: registry,
+		requests: registry.NewCounter("http_requests_total",
+			"Total number of HTTP requests.", "method", "route", "status"),
+		duration: registry.NewHistogram("http_request_duration_seconds",
+			"HTTP request latencies in seconds.", metrics.DefaultBuckets, "method", "route", "status"),
+		rateLimited: registry.NewCounter("http_rate_limited_requests_total",
+			"Total number of requests rejected by the rate limiter."),
+	}
+
+	registry.NewGaugeFunc("http_requests_in_flight", "Number of HTTP requests being served.", func() float64 {
+		return float64(m.inFlight.Load())
+	})
+	registry.NewGaugeFunc("background_tasks", "Number of goroutines started with app.background() that are still running.", func() float64 {
+		return float64(m.backgroundTasks.Load())
+	})
+	registry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	// The connection pool statistics are read from sql.DB.Stats() at scrape time.
+	stats := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return fn(db.Stats())
+		}
+	}
+	registry.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewGaugeFunc("db_open_connections", "Number of established connections, both in use and idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("db_in_use_connections", "Number of connections currently in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("db_idle_connections", "Number of idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewCounterFunc("db_wait_count_total", "Total number of connections waited for.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	registry.NewCounterFunc("db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+
+	return m
+}
+
+// router is a httprouter.Router that records the pattern of the matched route, such as
+// "/v1/remote-cars/:id", in the request's requestInfo. The metrics and the access log
+// use the pattern rather than the URL, so that every remote car doesn't get its own
+// time series. It also starts a span for each handler.
+type router struct {
+	*httprouter.Router
+}
+
+func (rt router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rt.Router.HandlerFunc(method, path, func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.route = path
+		}
+		// The handler gets a span of its own, so that the time spent in the middleware
+		// before it can be told apart from the time spent in the handler.
+		ctx, span := tracing.Start(r.Context(), "handler", tracing.SpanKindInternal, tracing.String("http.route", path))
+		defer span.End()
+
+		handler(w, r.WithContext(ctx))
+	})
+}
+
+// metricsMethod returns the method to label a request with. Unknown methods are grouped
+// together, because clients can send anything.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// metrics records the count and latency of every request, by method, route pattern and
+// status code. Requests that don't match a route are labelled "unmatched". It relies on
+// the logRequest() middleware, which runs before it, for the route and status code.
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.serverMetrics.inFlight.Add(1)
+		defer app.serverMetrics.inFlight.Add(-1)
+
+		next.ServeHTTP(w, r)
+
+		info := app.contextGetRequestInfo(r)
+
+		route := info.route
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := metricsMethod(r.Method)
+		status := strconv.Itoa(info.status)
+
+		app.serverMetrics.requests.Inc(method, route, status)
+		app.serverMetrics.duration.Observe(time.Since(start).Seconds(), method, route, status)
+	})
+}
+
+// metricsHandler serves the metrics in the Prometheus text format.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	app.serverMetrics.registry.Handler().ServeHTTP(w, r)
+}
+
+// requireBasicAuth protects a handler with HTTP basic authentication, using the
+// username and password set with the -metrics-username and -metrics-password flags.
+func (app *application) requireBasicAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+
+		usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(app.config.metrics.username)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(app.config.metrics.password)) == 1
+
+		if !ok || !usernameMatch || !passwordMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -5,12 +5,8 @@ import (
 	"assignment3.yerniyaz.net/internal/validator"
 	"errors"
 	"fmt"
-	"golang.org/x/time/rate"
-	"net"
 	"net/http"
 	"strings"
-	"sync"
-	"time"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -26,55 +22,6 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
-			mu.Unlock()
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
@@ -99,12 +46,26 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// using the invalidAuthenticationTokenResponse() helper (which we will create
 		// in a moment).
 		headerParts := strings.Split(authorizationHeader, " ")
+		// Basic credentials are only used for /debug/metrics, which checks them itself
+		// with requireBasicAuth(), so as far as the rest of the API is concerned the
+		// request is anonymous.
+		if len(headerParts) == 2 && headerParts[0] == "Basic" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
+		// Device keys are handled separately: the device is added to the request context
+		// alongside the AnonymousUser, so any route that expects a user still rejects it.
+		if data.BearerScope(token) == data.ScopeDevice {
+			app.authenticateDevice(w, r, next, token)
+			return
+		}
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 		// If the token isn't valid, use the invalidAuthenticationTokenResponse()
@@ -118,7 +79,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found. IMPORTANT: Notice that we are using
 		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.modelsFor(r).Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -136,6 +97,27 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+func (app *application) authenticateDevice(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	v := validator.New()
+	if data.ValidateDeviceKeyPlaintext(v, key); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	device, err := app.modelsFor(r).Devices.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	r = app.contextSetUser(r, data.AnonymousUser)
+	r = app.contextSetDevice(r, device)
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -168,7 +150,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
 		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -179,6 +161,11 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			app.notPermittedResponse(w, r)
 			return
 		}
+		// Users whose permissions require two-factor authentication can't use any of
+		// them until they have enabled it.
+		if !app.checkTwoFactorRequirement(w, r, user.ID, permissions) {
+			return
+		}
 		// Otherwise they have the required permission so we call the next handler in
 		// the chain.
 		next.ServeHTTP(w, r)
@@ -187,6 +174,44 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// requireDevice checks that the request was made with a device key, and that the :id
+// URL parameter is the ID of that same device.
+func (app *application) requireDevice(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		device := app.contextGetDevice(r)
+		if device == nil {
+			app.deviceRequiredResponse(w, r)
+			return
+		}
+		id, err := app.readIDParam(r)
+		if err != nil || id != device.ID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireDeviceOrPermission lets a request through if it was made either by the device
+// installed in the remote car identified by the :id URL parameter, or by a user with the
+// given permission.
+func (app *application) requireDeviceOrPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	withPermission := app.requirePermission(code, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		device := app.contextGetDevice(r)
+		if device == nil {
+			withPermission.ServeHTTP(w, r)
+			return
+		}
+		id, err := app.readIDParam(r)
+		if err != nil || id != device.RemoteCarID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -197,6 +222,9 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// Let browser clients read the request ID, so they can report it, and the
+					// rate limit headers.
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
 					// Check if the request has the HTTP method OPTIONS and contains the
 					// "Access-Control-Request-Method" header. If it does, then we treat
 					// it as a preflight request.
@@ -204,7 +232,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						// Set the necessary preflight response headers, as discussed
 						// previously.
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID, traceparent")
 						// Write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
 						w.WriteHeader(http.StatusOK)
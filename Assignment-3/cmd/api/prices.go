@@ -0,0 +1,190 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/validator"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+func (app *application) listPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-changed_at")
+
+	input.Filters.SortSafelist = []string{"changed_at", "cost", "-changed_at", "-cost"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	prices, metadata, err := app.modelsFor(r).Prices.GetHistory(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"prices": prices, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) setPriceAlertHandler(w http.ResponseWriter, r *http.Request) {
+	carID, err := app.readInt64Param(r, "car_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(carID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Threshold data.Cost `json:"threshold"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	alert := &data.PriceAlert{
+		UserID:      user.ID,
+		RemoteCarID: carID,
+		Threshold:   input.Threshold,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePriceAlert(v, alert); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Prices.SetAlert(alert)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"price_alert": alert}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePriceAlertHandler(w http.ResponseWriter, r *http.Request) {
+	carID, err := app.readInt64Param(r, "car_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.modelsFor(r).Prices.DeleteAlert(user.ID, carID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "price alert successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPriceAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	alerts, err := app.modelsFor(r).Prices.GetAlertsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"price_alerts": alerts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyPriceDrop queues an email for everyone watching a remote car about its price
+// falling from previousCost. It runs in a background goroutine so that looking up a
+// long list of recipients doesn't hold up the PATCH request that changed the price.
+// The queries are still recorded in that request's trace.
+func (app *application) notifyPriceDrop(r *http.Request, remotecars *data.RemoteCars, previousCost data.Cost) {
+	models := app.modelsFor(r)
+
+	app.background(func() {
+		recipients, err := models.Prices.GetDropRecipients(remotecars.ID, previousCost, remotecars.Cost)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		for _, recipient := range recipients {
+			// The costs are passed as plain numbers, because the template data is
+			// stored as JSON and data.Cost would be encoded as "N dollars".
+			data := map[string]interface{}{
+				"name":          recipient.Name,
+				"remoteCarID":   remotecars.ID,
+				"remoteCarName": remotecars.Name,
+				"previousCost":  int32(previousCost),
+				"cost":          int32(remotecars.Cost),
+				"threshold":     int32(recipient.Threshold),
+			}
+
+			err = models.Emails.Enqueue(recipient.Email, recipient.Locale, "price_drop.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"user_id": strconv.FormatInt(recipient.UserID, 10),
+				})
+			}
+		}
+	})
+}
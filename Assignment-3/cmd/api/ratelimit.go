@@ -0,0 +1,218 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/jsonlog"
+	"assignment3.yerniyaz.net/internal/limiter"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitTier gives the users holding a permission a limit of their own, such as a
+// higher one for fleet managers' integrations.
+type rateLimitTier struct {
+	permission string
+	limit      limiter.Limit
+}
+
+// parseRateLimitTiers parses the -limiter-tiers flag, a space separated list of
+// permission=rps:burst entries such as "remote-cars:write=10:20".
+func parseRateLimitTiers(val string) ([]rateLimitTier, error) {
+	var tiers []rateLimitTier
+
+	for _, field := range strings.Fields(val) {
+		permission, limit, ok := strings.Cut(field, "=")
+		if !ok || permission == "" {
+			return nil, fmt.Errorf("invalid rate limit tier %q (must be permission=rps:burst)", field)
+		}
+
+		l, err := parseLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit tier %q: %w", field, err)
+		}
+
+		tiers = append(tiers, rateLimitTier{permission: permission, limit: l})
+	}
+
+	return tiers, nil
+}
+
+func parseLimit(s string) (limiter.Limit, error) {
+	rps, burst, ok := strings.Cut(s, ":")
+	if !ok {
+		return limiter.Limit{}, fmt.Errorf("%q is not rps:burst", s)
+	}
+
+	var (
+		l   limiter.Limit
+		err error
+	)
+
+	l.Rate, err = strconv.ParseFloat(rps, 64)
+	if err != nil || l.Rate <= 0 {
+		return limiter.Limit{}, fmt.Errorf("%q is not a positive number of requests per second", rps)
+	}
+
+	l.Burst, err = strconv.Atoi(burst)
+	if err != nil || l.Burst < 1 {
+		return limiter.Limit{}, fmt.Errorf("%q is not a positive burst", burst)
+	}
+
+	return l, nil
+}
+
+// rateLimit applies the general rate limit to every request. It runs after
+// authenticate(), so that authenticated users are limited by user ID, with the limit
+// of the highest tier their permissions qualify them for, and remote cars by device.
+// Anyone else is limited by IP address. Routes that need a stricter limit as well are
+// wrapped in limitRoute().
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	// Every minute, remove the buckets of clients that haven't been seen for three
+	// minutes. With the Postgres store every instance does this, which is harmless.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			err := app.limiter.Prune(context.Background(), 3*time.Minute)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			key   string
+			limit limiter.Limit
+		)
+
+		user := app.contextGetUser(r)
+
+		switch device := app.contextGetDevice(r); {
+		case !user.IsAnonymous():
+			key = "user:" + strconv.FormatInt(user.ID, 10)
+			limit = app.config.limiter.user
+
+			if len(app.config.limiter.tiers) > 0 {
+				permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+				limit = tierLimit(limit, app.config.limiter.tiers, permissions)
+			}
+		case device != nil:
+			key = "device:" + strconv.FormatInt(device.ID, 10)
+			limit = app.config.limiter.user
+		default:
+			key = "ip:" + app.contextGetClientIP(r)
+			limit = app.config.limiter.anonymous
+		}
+
+		if !app.takeToken(w, r, key, limit) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitCredentials runs before authenticate() and limits, per IP address, the
+// requests that send a token or device key. Looking up a credential costs a database
+// query, and a 401 Unauthorized response tells the client that its guess was wrong, so
+// without this anyone could guess at tokens and device keys as fast as we answer.
+// Requests without credentials are left to rateLimit(), which limits them by IP
+// address anyway.
+func (app *application) rateLimitCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled || r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !app.takeToken(w, r, "credentials:ip:"+app.contextGetClientIP(r), app.config.limiter.credentials) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRoute applies a stricter limit, on top of the general one, to a group of routes
+// that are attractive to abuse, such as logging in and signing up. The group's limit is
+// per IP address and shared by all its routes, since these routes are mostly used
+// before anyone has authenticated.
+func (app *application) limitRoute(group string, limit limiter.Limit, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next(w, r)
+			return
+		}
+
+		if !app.takeToken(w, r, group+":ip:"+app.contextGetClientIP(r), limit) {
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// tierLimit returns the most generous of the limit and the limits of the tiers for the
+// permissions held.
+func tierLimit(limit limiter.Limit, tiers []rateLimitTier, permissions data.Permissions) limiter.Limit {
+	for _, tier := range tiers {
+		if permissions.Include(tier.permission) && tier.limit.Rate > limit.Rate {
+			limit = tier.limit
+		}
+	}
+	return limit
+}
+
+// takeToken takes a token from the bucket for key and sets the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers. If the request isn't allowed it
+// sends a 429 Too Many Requests response, with a Retry-After header, and returns false.
+//
+// When a request is subject to more than one limit, the headers describe whichever
+// has the fewest requests remaining.
+//
+// If the store can't be reached, the request is let through rather than turning a
+// problem with the rate limiter into an outage.
+func (app *application) takeToken(w http.ResponseWriter, r *http.Request, key string, limit limiter.Limit) bool {
+	result, err := app.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		app.requestLogger(r).Warn("rate limiter unavailable", jsonlog.Err(err))
+		return true
+	}
+
+	header := w.Header()
+
+	if previous, err := strconv.Atoi(header.Get("RateLimit-Remaining")); err != nil || result.Remaining <= previous {
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+	}
+
+	if !result.Allowed {
+		header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+		app.serverMetrics.rateLimited.Inc()
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// ceilSeconds formats a duration as a whole number of seconds, rounding up so that a
+// client that waits that long will find the limit has reset.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
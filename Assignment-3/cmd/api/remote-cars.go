@@ -8,12 +8,23 @@ import (
 	"net/http"
 )
 
+// remoteCarsSortSafelist holds the sort values supported by every endpoint that lists
+// remote cars.
+var remoteCarsSortSafelist = []string{"id", "name", "year", "cost", "rating", "-id", "-name", "-year", "-cost", "-rating"}
+
+// remoteCarsSearchSortSafelist adds sorting by distance, which is only meaningful for
+// the main list endpoint when a near point is given.
+var remoteCarsSearchSortSafelist = []string{"id", "name", "year", "cost", "rating", "distance", "-id", "-name", "-year", "-cost", "-rating", "-distance"}
+
 func (app *application) createRemoteCarsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name        string    `json:"name"`
-		Year        int32     `json:"year"`
-		Cost        data.Cost `json:"cost"`
-		Description string    `json:"description"`
+		Name        string         `json:"name"`
+		Year        int32          `json:"year"`
+		Cost        data.Cost      `json:"cost"`
+		Description string         `json:"description"`
+		Categories  []string       `json:"categories"`
+		Tags        []string       `json:"tags"`
+		Location    *data.Location `json:"location"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -27,18 +38,27 @@ func (app *application) createRemoteCarsHandler(w http.ResponseWriter, r *http.R
 		Year:        input.Year,
 		Cost:        input.Cost,
 		Description: input.Description,
+		Categories:  input.Categories,
+		Tags:        input.Tags,
+		Location:    input.Location,
 	}
 
 	v := validator.New()
 
 	if data.ValidateRemoteCars(v, remotecars); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.RemoteCars.Insert(remotecars)
+	err = app.modelsFor(r).RemoteCars.Insert(remotecars)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrUnknownCategory):
+			v.AddError("categories", validator.ExistingCategories)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -58,7 +78,7 @@ func (app *application) showRemoteCarsHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	remotecars, err := app.models.RemoteCars.Get(id)
+	remotecars, err := app.modelsFor(r).RemoteCars.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -68,6 +88,13 @@ func (app *application) showRemoteCarsHandler(w http.ResponseWriter, r *http.Req
 		}
 		return
 	}
+
+	err = app.markFavorites(r, remotecars)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"classiccars": remotecars}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -82,7 +109,7 @@ func (app *application) updateRemoteCarsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	remotecars, err := app.models.RemoteCars.Get(id)
+	remotecars, err := app.modelsFor(r).RemoteCars.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -94,10 +121,13 @@ func (app *application) updateRemoteCarsHandler(w http.ResponseWriter, r *http.R
 	}
 
 	var input struct {
-		Name        *string    `json:"name"`
-		Year        *int32     `json:"year"`
-		Cost        *data.Cost `json:"cost"`
-		Description *string    `json:"description"`
+		Name        *string        `json:"name"`
+		Year        *int32         `json:"year"`
+		Cost        *data.Cost     `json:"cost"`
+		Description *string        `json:"description"`
+		Categories  []string       `json:"categories"`
+		Tags        []string       `json:"tags"`
+		Location    *data.Location `json:"location"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -106,6 +136,8 @@ func (app *application) updateRemoteCarsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	previousCost := remotecars.Cost
+
 	if input.Name != nil {
 		remotecars.Name = *input.Name
 	}
@@ -118,24 +150,40 @@ func (app *application) updateRemoteCarsHandler(w http.ResponseWriter, r *http.R
 	if input.Description != nil {
 		remotecars.Description = *input.Description
 	}
+	if input.Categories != nil {
+		remotecars.Categories = input.Categories
+	}
+	if input.Tags != nil {
+		remotecars.Tags = input.Tags
+	}
+	if input.Location != nil {
+		remotecars.Location = input.Location
+	}
 
 	v := validator.New()
 	if data.ValidateRemoteCars(v, remotecars); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.RemoteCars.Update(remotecars)
+	err = app.modelsFor(r).RemoteCars.Update(remotecars)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownCategory):
+			v.AddError("categories", validator.ExistingCategories)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if remotecars.Cost < previousCost {
+		app.notifyPriceDrop(r, remotecars, previousCost)
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"remotecars": remotecars}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -149,7 +197,7 @@ func (app *application) deleteRemoteCarsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.models.RemoteCars.Delete(id)
+	err = app.modelsFor(r).RemoteCars.Delete(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -170,6 +218,11 @@ func (app *application) listRemoteCarsHandler(w http.ResponseWriter, r *http.Req
 	var input struct {
 		Name        string
 		Description string
+		Categories  []string
+		Tags        []string
+		Match       string
+		Near        *data.Location
+		RadiusKm    float64
 		data.Filters
 	}
 
@@ -178,19 +231,41 @@ func (app *application) listRemoteCarsHandler(w http.ResponseWriter, r *http.Req
 	qs := r.URL.Query()
 
 	input.Name = app.readString(qs, "name", "")
+	input.Categories = app.readCSV(qs, "categories", []string{})
+	input.Tags = app.readCSV(qs, "tags", []string{})
+	input.Match = app.readString(qs, "match", data.MatchAny)
+	input.Near = app.readLocation(qs, "near", v)
+	input.RadiusKm = app.readFloat(qs, "radius_km", 10, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
-	input.Filters.SortSafelist = []string{"id", "name", "year", "cost", "-id", "-name", "-year", "-cost"}
+	input.Filters.SortSafelist = remoteCarsSearchSortSafelist
+
+	v.Check(validator.In(input.Match, data.MatchAny, data.MatchAll), "match", validator.OneOf, "any, all")
+
+	if input.Near != nil {
+		data.ValidateLocation(v, "near", input.Near)
+		v.Check(input.RadiusKm > 0, "radius_km", validator.GreaterThanZero)
+		v.Check(input.RadiusKm <= 1000, "radius_km", validator.Max, 1000)
+	} else {
+		v.Check(qs.Get("radius_km") == "", "radius_km", validator.OnlyWith, "near")
+		v.Check(!validator.In(input.Filters.Sort, "distance", "-distance"), "sort", validator.SortRequires, "distance", "near")
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	remotecars, metadata, err := app.modelsFor(r).RemoteCars.GetAll(input.Name, input.Categories, input.Tags, input.Match, input.Near, input.RadiusKm, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	remotecars, metadata, err := app.models.RemoteCars.GetAll(input.Name, input.Filters)
+	err = app.markFavorites(r, remotecars...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/jsonlog"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// requestIDRX matches the client-supplied request IDs that we accept. Anything else is
+// replaced with one of our own, so that clients can't inject arbitrary text into the
+// logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// newRequestID returns a random 128-bit request ID, hex encoded.
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder records the status code and size of a response in the request's
+// requestInfo. It passes Flush() and Hijack() through to the wrapped
+// http.ResponseWriter, and implements Unwrap() so that http.ResponseController can
+// reach it too, which the event streams and WebSockets rely on.
+type responseRecorder struct {
+	wrapped       http.ResponseWriter
+	info          *requestInfo
+	headerWritten bool
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.wrapped.Header()
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	rr.wrapped.WriteHeader(statusCode)
+	if !rr.headerWritten {
+		rr.info.status = statusCode
+		rr.headerWritten = true
+	}
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.headerWritten = true
+	n, err := rr.wrapped.Write(b)
+	rr.info.bytes += n
+	return n, err
+}
+
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.wrapped.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	rr.headerWritten = true
+}
+
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rr.wrapped.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported")
+	}
+	// A hijacked connection is only used for WebSocket upgrades.
+	rr.info.status = http.StatusSwitchingProtocols
+	rr.headerWritten = true
+	return hijacker.Hijack()
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.wrapped
+}
+
+// logRequest is the outermost middleware. It gives every request an ID, taken from the
+// X-Request-ID header if the client (or a proxy in front of us) sent a valid one, and
+// echoes it back in the response's X-Request-ID header. Once the request has been
+// handled, it writes a line to the access log.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{id: id, clientIP: app.clientIP(r), status: http.StatusOK}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+
+		next.ServeHTTP(&responseRecorder{wrapped: w, info: info}, r)
+
+		route := info.route
+		if route == "" {
+			route = "unmatched"
+		}
+
+		app.requestLogger(r).Info("request",
+			jsonlog.String("method", r.Method),
+			jsonlog.String("route", route),
+			jsonlog.Int("status", info.status),
+			jsonlog.Int("bytes", info.bytes),
+			jsonlog.Duration("duration", time.Since(start)),
+			jsonlog.String("ip", info.clientIP),
+		)
+	})
+}
+
+// requestLogger returns a logger that adds the request ID, the trace ID if the request
+// is being traced, and the user ID once the request has been authenticated, to every
+// entry it writes.
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	info := app.contextGetRequestInfo(r)
+
+	attrs := []jsonlog.Attr{jsonlog.String("request_id", info.id)}
+	if info.traceID != "" {
+		attrs = append(attrs, jsonlog.String("trace_id", info.traceID))
+	}
+	if info.userID != 0 {
+		attrs = append(attrs, jsonlog.Int64("user_id", info.userID))
+	}
+
+	return app.logger.With(attrs...)
+}
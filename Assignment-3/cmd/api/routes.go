@@ -6,12 +6,22 @@ import (
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := router{httprouter.New()}
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthz", app.healthzHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/readyz", app.readyzHandler)
+	// The old health check is kept as an alias of the liveness check, so that existing
+	// clients and monitors keep working.
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthzHandler)
+
+	// The metrics are only served here when they don't have a listener of their own,
+	// and a password has been set to protect them.
+	if app.config.metrics.addr == "" && app.config.metrics.password != "" {
+		router.HandlerFunc(http.MethodGet, "/debug/metrics", app.requireBasicAuth(app.metricsHandler))
+	}
 
 	router.HandlerFunc(http.MethodGet, "/v1/remote-cars", app.requirePermission("remote-cars:read", app.listRemoteCarsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/remote-cars", app.requirePermission("remote-cars:write", app.createRemoteCarsHandler))
@@ -19,11 +29,70 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPatch, "/v1/remote-cars/:id", app.requirePermission("remote-cars:write", app.updateRemoteCarsHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/remote-cars/:id", app.requirePermission("remote-cars:write", app.deleteRemoteCarsHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/remote-cars/:id/reviews", app.requirePermission("remote-cars:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/remote-cars/:id/reviews", app.requirePermission("reviews:write", app.createReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/remote-cars/:id/reviews", app.requireActivatedUser(app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/remote-cars/:id/reviews", app.requireActivatedUser(app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/remote-cars/:id/prices", app.requirePermission("remote-cars:read", app.listPriceHistoryHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/remote-cars/:id/telemetry", app.requirePermission("remote-cars:read", app.listTelemetryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/remote-cars/:id/telemetry", app.requireDeviceOrPermission("telemetry:write", app.createTelemetryHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/remote-cars/:id/commands", app.requirePermission("remote-cars:control", app.createCommandHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/remote-cars/:id/commands/:command_id", app.requirePermission("remote-cars:control", app.showCommandHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/remote-cars/:id/live", app.requirePermission("remote-cars:read", app.liveRemoteCarHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/devices", app.requirePermission("devices:write", app.listDevicesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/devices", app.requirePermission("devices:write", app.createDeviceHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/devices/:id", app.requirePermission("devices:write", app.showDeviceHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/devices/:id", app.requirePermission("devices:write", app.deleteDeviceHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/devices/:id/key", app.requirePermission("devices:write", app.rotateDeviceKeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/devices/:id/commands/next", app.requireDevice(app.nextCommandHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/devices/:id/acknowledgements/:command_id", app.requireDevice(app.acknowledgeCommandHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:write", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:write", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:write", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries/:delivery_id", app.requirePermission("webhooks:write", app.showWebhookDeliveryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/replay", app.requirePermission("webhooks:write", app.replayWebhookDeliveryHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/emails", app.requirePermission("emails:write", app.listEmailsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/emails/:id", app.requirePermission("emails:write", app.showEmailHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/emails/:id/requeue", app.requirePermission("emails:write", app.requeueEmailHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.requirePermission("remote-cars:read", app.listCategoriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requirePermission("categories:write", app.createCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.requirePermission("remote-cars:read", app.showCategoryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.requirePermission("categories:write", app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requirePermission("categories:write", app.deleteCategoryHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/events", app.requireActivatedUser(app.eventsHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.limitRoute("auth", app.config.limiter.auth, app.registerUserHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa", app.requireActivatedUser(app.enrollTwoFactorHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/2fa/confirmed", app.requireActivatedUser(app.confirmTwoFactorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/2fa", app.requireActivatedUser(app.disableTwoFactorHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/favorites", app.requireActivatedUser(app.listFavoritesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/favorites/:car_id", app.requireActivatedUser(app.addFavoriteHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/favorites/:car_id", app.requireActivatedUser(app.removeFavoriteHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/price-alerts", app.requireActivatedUser(app.listPriceAlertsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/price-alerts/:car_id", app.requireActivatedUser(app.setPriceAlertHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/price-alerts/:car_id", app.requireActivatedUser(app.deletePriceAlertHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.limitRoute("auth", app.config.limiter.auth, app.createAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.limitRoute("auth", app.config.limiter.auth, app.createTwoFactorTokenHandler))
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+	return app.logRequest(app.trace(app.metrics(app.recoverPanic(app.enableCORS(app.rateLimitCredentials(app.authenticate(app.rateLimit(router))))))))
 
 }
@@ -19,6 +19,53 @@ func (app *application) serve() error {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	// Start relaying database notifications to the /v1/events streams and the live
+	// WebSockets, sending webhooks and queued emails, and pruning failed logins. These
+	// are all stopped, along with the event streams, as soon as shutdown begins; the
+	// webhook dispatcher and the email worker are tracked by the WaitGroup so that
+	// anything already being sent gets to finish.
+	stopBackground := make(chan struct{})
+	go app.listenForNotifications(stopBackground)
+	app.background(func() {
+		app.dispatchWebhooks(stopBackground)
+	})
+	app.background(func() {
+		app.sendQueuedEmails(stopBackground)
+	})
+	app.background(func() {
+		app.pruneLoginFailures(stopBackground)
+	})
+	// If the metrics have a listener of their own, start it alongside the API. It is
+	// shut down with the API, below.
+	var metricsSrv *http.Server
+	if app.config.metrics.addr != "" {
+		handler := app.metricsHandler
+		if app.config.metrics.password != "" {
+			handler = app.requireBasicAuth(handler)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/metrics", handler)
+		metricsSrv = &http.Server{
+			Addr:         app.config.metrics.addr,
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		go func() {
+			app.logger.PrintInfo("starting metrics server", map[string]string{
+				"addr": metricsSrv.Addr,
+			})
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, nil)
+			}
+		}()
+	}
+	srv.RegisterOnShutdown(func() {
+		close(stopBackground)
+		app.events.close()
+	})
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -27,14 +74,26 @@ func (app *application) serve() error {
 		app.logger.PrintInfo("caught signal", map[string]string{
 			"signal": s.String(),
 		})
+		// Fail the readiness check, and give load balancers time to notice and stop
+		// sending us new requests, before the server stops accepting them.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.shutdown.drainDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		// Call Shutdown() on the server like before, but now we only send on the
 		// shutdownError channel if it returns an error.
+		if metricsSrv != nil {
+			metricsSrv.Shutdown(ctx)
+		}
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
 		}
+		// Shutdown() doesn't wait for hijacked connections, so close the live
+		// WebSockets explicitly, giving each client a close frame before the process
+		// exits.
+		app.live.close()
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
@@ -45,6 +104,14 @@ func (app *application) serve() error {
 		// the shutdownError channel, to indicate that the shutdown completed without
 		// any issues.
 		app.wg.Wait()
+		// Export the spans of the last requests and background tasks. This gets a
+		// fresh timeout, as the one above may well have been used up.
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = app.tracer.Shutdown(ctx)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
 		shutdownError <- nil
 	}()
 	app.logger.PrintInfo("starting server", map[string]string{
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/validator"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// createTelemetryHandler accepts a batch of readings as newline-delimited JSON, with
+// one reading object per line. The whole batch is validated before anything is
+// stored, and validation errors are keyed by line number so that the device can tell
+// which readings were rejected.
+func (app *application) createTelemetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	readings, err := app.readTelemetry(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	for i, reading := range readings {
+		lv := validator.New()
+		data.ValidateTelemetryReading(lv, reading)
+		for key, e := range lv.Errors {
+			v.AddError(fmt.Sprintf("line_%d.%s", i+1, key), e.Code, e.Args...)
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Telemetry.Insert(id, readings)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"readings": len(readings)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTelemetry decodes an NDJSON request body into a slice of readings. Blank lines
+// are skipped, and the errors follow the same wording as readJSON() with the offending
+// line number added.
+func (app *application) readTelemetry(w http.ResponseWriter, r *http.Request) ([]*data.TelemetryReading, error) {
+	maxBytes := 5_242_880
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	scanner := bufio.NewScanner(r.Body)
+
+	readings := []*data.TelemetryReading{}
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		if len(readings) == data.MaxTelemetryBatch {
+			return nil, fmt.Errorf("body must not contain more than %d readings", data.MaxTelemetryBatch)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+
+		var reading data.TelemetryReading
+
+		err := dec.Decode(&reading)
+		if err != nil {
+			var syntaxError *json.SyntaxError
+			var unmarshalTypeError *json.UnmarshalTypeError
+			switch {
+			case errors.As(err, &syntaxError):
+				return nil, fmt.Errorf("line %d contains badly-formed JSON (at character %d)", line, syntaxError.Offset)
+			case errors.As(err, &unmarshalTypeError):
+				return nil, fmt.Errorf("line %d contains incorrect JSON type for field %q", line, unmarshalTypeError.Field)
+			case strings.HasPrefix(err.Error(), "json: unknown field "):
+				fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+				return nil, fmt.Errorf("line %d contains unknown key %s", line, fieldName)
+			default:
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		if dec.More() {
+			return nil, fmt.Errorf("line %d must only contain a single JSON value", line)
+		}
+
+		readings = append(readings, &reading)
+	}
+
+	if err := scanner.Err(); err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return nil, fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		case errors.Is(err, bufio.ErrTooLong):
+			return nil, fmt.Errorf("line %d is too long", line+1)
+		default:
+			return nil, err
+		}
+	}
+
+	if len(readings) == 0 {
+		return nil, errors.New("body must not be empty")
+	}
+
+	return readings, nil
+}
+
+// listTelemetryHandler returns the telemetry for a remote car downsampled to the
+// requested resolution. By default it covers the last hour in one-minute buckets.
+func (app *application) listTelemetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		From       time.Time
+		To         time.Time
+		Resolution time.Duration
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	now := time.Now()
+
+	input.To = app.readTime(qs, "to", now, v)
+	input.From = app.readTime(qs, "from", input.To.Add(-time.Hour), v)
+	input.Resolution = app.readDuration(qs, "resolution", time.Minute, v)
+
+	v.Check(input.From.Before(input.To), "from", validator.Before, "to")
+	v.Check(input.Resolution >= time.Second, "resolution", validator.MinDuration, "1s")
+	v.Check(input.Resolution%time.Second == 0, "resolution", validator.WholeSeconds)
+	if input.Resolution > 0 {
+		v.Check(input.To.Sub(input.From)/input.Resolution <= 10_000, "resolution", validator.MaxBuckets, 10_000)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	_, err = app.modelsFor(r).RemoteCars.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	telemetry, err := app.modelsFor(r).Telemetry.GetAggregates(id, input.From, input.To, input.Resolution)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"telemetry":  telemetry,
+		"from":       input.From,
+		"to":         input.To,
+		"resolution": input.Resolution.String(),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,9 +5,32 @@ import (
 	"assignment3.yerniyaz.net/internal/validator"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var (
+	// accountLoginPolicy slows down guessing the password of a single account, and
+	// locks it out for a while if the guessing carries on.
+	accountLoginPolicy = data.LoginPolicy{
+		DelayAfter:   3,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 10,
+		Lockout:      15 * time.Minute,
+		Window:       time.Hour,
+	}
+	// ipLoginPolicy does the same for a single IP address trying many accounts. It is
+	// more lenient, as many people can share an address behind NAT.
+	ipLoginPolicy = data.LoginPolicy{
+		DelayAfter:   10,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 100,
+		Lockout:      15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the email and password from the request body.
 	var input struct {
@@ -24,17 +47,35 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	data.ValidateEmail(v, input.Email)
 	data.ValidatePasswordPlaintext(v, input.Password)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// Refuse to check the credentials at all while the account or the client's IP
+	// address is blocked after too many failed attempts. The account is tracked by
+	// email address, whether or not anyone has it, so that being blocked doesn't
+	// reveal that an account exists.
+	accountKey := "account:" + strings.ToLower(input.Email)
+	ipKey := "ip:" + app.contextGetClientIP(r)
+
+	retryAfter, err := app.modelsFor(r).Logins.Blocked(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
 		return
 	}
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client (we will create this helper in a moment).
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// found, we still spend as long checking the password as we would for a real
+	// account, so that the response time doesn't give away which emails are in use,
+	// and then send the same 401 Unauthorized response as for a wrong password.
+	user, err := app.modelsFor(r).Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			data.CheckDummyPassword(input.Password)
+			app.loginFailed(w, r, nil, accountKey, ipKey)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -46,15 +87,40 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, then we record the failure and return.
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.loginFailed(w, r, user, accountKey, ipKey)
+		return
+	}
+	// A successful login clears the account's failures. The IP address's are kept, as
+	// someone guessing at many accounts may well know the password to one of them.
+	err = app.modelsFor(r).Logins.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Users with two-factor authentication enabled get a short-lived 2fa-pending token
+	// instead, to exchange along with a code at POST /v1/tokens/2fa.
+	enabled, err := app.modelsFor(r).TwoFactor.Enabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enabled {
+		token, err := app.modelsFor(r).Tokens.New(user.ID, twoFactorPendingTTL, data.ScopeTwoFactorPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusCreated, envelope{"2fa_pending_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
 	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.modelsFor(r).Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -67,3 +133,61 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// loginFailed records a failed login against the account and the IP address and sends
+// the 401 Unauthorized response. If this locks out an account that exists, its owner
+// is emailed about it; the email is queued in the background so that the response
+// takes no longer for a real account than for an unknown one.
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, user *data.User, accountKey, ipKey string) {
+	models := app.modelsFor(r)
+
+	lockedOut, err := models.Logins.RecordFailure(accountKey, accountLoginPolicy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = models.Logins.RecordFailure(ipKey, ipLoginPolicy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if lockedOut && user != nil {
+		app.background(func() {
+			data := map[string]interface{}{
+				"name":    user.Name,
+				"minutes": int(accountLoginPolicy.Lockout.Minutes()),
+			}
+
+			err := models.Emails.Enqueue(user.Email, user.Locale, "account_locked.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"user_id": strconv.FormatInt(user.ID, 10),
+				})
+			}
+		})
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// pruneLoginFailures removes, every hour, the failed logins that have been forgotten,
+// so that the table doesn't keep a row for every email address ever guessed at.
+func (app *application) pruneLoginFailures(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		err := app.models.Logins.DeleteExpired(ipLoginPolicy.Window)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
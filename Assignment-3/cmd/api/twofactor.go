@@ -0,0 +1,342 @@
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/data"
+	"assignment3.yerniyaz.net/internal/totp"
+	"assignment3.yerniyaz.net/internal/validator"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// twoFactorIssuer is the name authenticator apps show next to the codes.
+	twoFactorIssuer = "Remote Cars"
+	// twoFactorPendingTTL is how long a user has, after logging in with their
+	// password, to send a code.
+	twoFactorPendingTTL = 5 * time.Minute
+)
+
+// enrollTwoFactorHandler starts enabling two-factor authentication for the current user
+// by generating a TOTP secret. The user adds it to their authenticator app, from the
+// otpauth URI or by typing in the secret, and then sends a code from the app to
+// confirmTwoFactorHandler. Until then the secret isn't used, and enrolling again
+// replaces it.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).TwoFactor.Enroll(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			app.twoFactorEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"two_factor": map[string]string{
+			"secret":      totp.EncodeSecret(secret),
+			"otpauth_uri": totp.URI(twoFactorIssuer, user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler enables two-factor authentication once the user has sent a
+// valid code for the secret from enrollTwoFactorHandler, and responds with the
+// recovery codes, which are never shown again. All the user's authentication tokens
+// are deleted, so every session from then on has been through the second step.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Code != "", "code", validator.Required); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	tf, err := app.modelsFor(r).TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if tf.Enabled() {
+		app.twoFactorEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Validate(tf.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", validator.InvalidTOTPCode)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	codes, err := app.modelsFor(r).TwoFactor.Confirm(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.twoFactorEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactorHandler turns two-factor authentication off, given a code from the
+// authenticator app or a recovery code. Users whose permissions require two-factor
+// authentication can't turn it off.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Code != "", "code", validator.Required); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	tf, err := app.modelsFor(r).TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !tf.Enabled() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.twoFactorRequired(permissions) {
+		app.twoFactorRequiredResponse(w, r)
+		return
+	}
+
+	ok, err := app.checkTwoFactorCode(r, tf, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", validator.InvalidTOTPCode)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).TwoFactor.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTwoFactorTokenHandler is the second step of logging in for users with
+// two-factor authentication enabled. It exchanges the 2fa-pending token from
+// createAuthenticationTokenHandler, along with a code from the authenticator app or a
+// recovery code, for an authentication token.
+//
+// Wrong codes are counted against the user in the same way as wrong passwords, so
+// that the codes can't be guessed within the lifetime of the pending token.
+func (app *application) createTwoFactorTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.Token)
+	v.Check(input.Code != "", "code", validator.Required)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopeTwoFactorPending, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.twoFactorLoginExpiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	failureKey := "2fa:" + strconv.FormatInt(user.ID, 10)
+
+	retryAfter, err := app.modelsFor(r).Logins.Blocked(failureKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	tf, err := app.modelsFor(r).TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.twoFactorLoginExpiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.checkTwoFactorCode(r, tf, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		_, err = app.modelsFor(r).Logins.RecordFailure(failureKey, accountLoginPolicy)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidTwoFactorCodeResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).Logins.Reset(failureKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeTwoFactorPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.modelsFor(r).Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkTwoFactorCode checks a code from the user's authenticator app or, if it isn't
+// the length of one, one of their recovery codes. Either kind of code is used up, so
+// it can't be accepted again.
+func (app *application) checkTwoFactorCode(r *http.Request, tf *data.TwoFactor, code string) (bool, error) {
+	if len(strings.ReplaceAll(code, " ", "")) != totp.Digits {
+		return app.modelsFor(r).TwoFactor.UseRecoveryCode(tf.UserID, code)
+	}
+
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.modelsFor(r).TwoFactor.UseStep(tf.UserID, step)
+}
+
+// twoFactorRequired reports whether any of the permissions is one that the
+// -2fa-required-permissions flag says needs two-factor authentication.
+func (app *application) twoFactorRequired(permissions data.Permissions) bool {
+	for _, code := range app.config.twoFactor.requiredPermissions {
+		if permissions.Include(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTwoFactorRequirement checks that a user whose permissions require two-factor
+// authentication has enabled it. If not, or if the check fails, it sends the response
+// and returns false. Handlers that look at the user's permissions themselves, rather
+// than going through requirePermission(), have to call it before using them.
+func (app *application) checkTwoFactorRequirement(w http.ResponseWriter, r *http.Request, userID int64, permissions data.Permissions) bool {
+	if !app.twoFactorRequired(permissions) {
+		return true
+	}
+
+	enabled, err := app.modelsFor(r).TwoFactor.Enabled(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !enabled {
+		app.twoFactorRequiredResponse(w, r)
+		return false
+	}
+
+	return true
+}
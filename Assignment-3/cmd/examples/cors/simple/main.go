@@ -7,7 +7,7 @@ import (
 )
 
 // Define a string constant containing the HTML for the webpage. This consists of a <h1>
-// header tag, and some JavaScript which fetches the JSON from our GET /v1/healthcheck
+// header tag, and some JavaScript which fetches the JSON from our GET /v1/healthz
 // endpoint and writes it to inside the <div id="output"></div> element.
 const html = `
 <!DOCTYPE html>
@@ -20,7 +20,7 @@ const html = `
 <div id="output"></div>
 <script>
 document.addEventListener('DOMContentLoaded', function() {
-fetch("http://localhost:4000/v1/healthcheck").then(
+fetch("http://localhost:4000/v1/healthz").then(
 function (response) {
 response.text().then(function (text) {
 document.getElementById("output").innerHTML = text;
@@ -0,0 +1,246 @@
+// Command mailpreview renders the email templates with sample data, so that they can be
+// checked without registering users or triggering price drops. It always checks that
+// every template parses and defines the "subject", "plainBody" and "htmlBody" blocks
+// that mailer.Mailer needs, and exits with a non-zero status if any of them don't.
+//
+// Usage:
+//
+//	go run ./cmd/mailpreview                                   # check the templates
+//	go run ./cmd/mailpreview -out ./tmp/preview                # write .html and .txt files
+//	go run ./cmd/mailpreview -serve :4001 -dir ./internal/mailer/templates
+//
+// With -dir the templates are read from disk on every request, so edits show up on
+// refresh without restarting.
+package main
+
+import (
+	"assignment3.yerniyaz.net/internal/mailer"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sampleData holds the data that each template is rendered with, keyed by template
+// file name. It should have the same keys as the data passed to Enqueue() by the API.
+var sampleData = map[string]map[string]interface{}{
+	"user_welcome.tmpl": {
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"userID":          123,
+	},
+	"price_drop.tmpl": {
+		"name":          "Alice",
+		"remoteCarID":   42,
+		"remoteCarName": "Traxxas Slash 4x4",
+		"previousCost":  350,
+		"cost":          299,
+		"threshold":     300,
+	},
+	"account_locked.tmpl": {
+		"name":    "Alice",
+		"minutes": 15,
+	},
+}
+
+type config struct {
+	dir   string
+	out   string
+	serve string
+	data  string
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.dir, "dir", "", "Read the templates from this directory instead of the ones built into the binary")
+	flag.StringVar(&cfg.out, "out", "", "Write the rendered emails to this directory")
+	flag.StringVar(&cfg.serve, "serve", "", "Serve the rendered emails on this address, such as :4001")
+	flag.StringVar(&cfg.data, "data", "", "JSON file with template data, keyed by template file name, to use instead of the samples")
+	flag.Parse()
+
+	fsys := mailer.Templates()
+	if cfg.dir != "" {
+		fsys = os.DirFS(cfg.dir)
+	}
+
+	if cfg.data != "" {
+		err := loadData(cfg.data)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	paths, err := mailer.ListTemplates(fsys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(paths) == 0 {
+		log.Fatal("no templates found")
+	}
+
+	failed := false
+	for _, p := range paths {
+		_, err := render(fsys, p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+
+	if cfg.out != "" {
+		err = writeFiles(fsys, paths, cfg.out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %d templates to %s", len(paths), cfg.out)
+	}
+
+	if cfg.serve != "" {
+		log.Printf("serving previews on %s", cfg.serve)
+		log.Fatal(http.ListenAndServe(cfg.serve, previewHandler(fsys)))
+	}
+
+	if cfg.out == "" && cfg.serve == "" {
+		log.Printf("all %d templates are valid", len(paths))
+	}
+}
+
+// loadData replaces the sample data with the contents of a JSON file.
+func loadData(file string) error {
+	js, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(js, &sampleData)
+}
+
+// render checks the template file and renders it with its sample data.
+func render(fsys fs.FS, p string) (*mailer.Email, error) {
+	err := mailer.CheckTemplate(fsys, p)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := mailer.Render(fsys, p, sampleData[path.Base(p)])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	return email, nil
+}
+
+// plainText formats the subject and plain-text body of an email the way an email
+// client would show them.
+func plainText(email *mailer.Email) string {
+	return "Subject: " + email.Subject + "\n" + email.PlainBody
+}
+
+// writeFiles renders each template file to a .html and a .txt file under dir, keeping
+// the locale directories, so "ru/price_drop.tmpl" becomes ru/price_drop.html and
+// ru/price_drop.txt.
+func writeFiles(fsys fs.FS, paths []string, dir string) error {
+	for _, p := range paths {
+		email, err := render(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(p, ".tmpl")))
+
+		err = os.MkdirAll(filepath.Dir(name), 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(name+".html", []byte(email.HTMLBody), 0o644)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(name+".txt", []byte(plainText(email)), 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var index = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Email previews</title>
+</head>
+<body>
+<h1>Email previews</h1>
+<ul>
+{{range .}}<li>{{.}}: <a href="/{{.}}.html">HTML</a> | <a href="/{{.}}.txt">plain text</a></li>
+{{end}}</ul>
+</body>
+</html>`))
+
+// previewHandler serves an index of the templates at "/", and each rendered email at
+// its path with the .tmpl extension replaced by .html or .txt. The templates are
+// rendered on every request.
+func previewHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths, err := mailer.ListTemplates(fsys)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Path == "/" {
+			names := make([]string, len(paths))
+			for i, p := range paths {
+				names[i] = strings.TrimSuffix(p, ".tmpl")
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			index.Execute(w, names)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		ext := path.Ext(name)
+		p := strings.TrimSuffix(name, ext) + ".tmpl"
+
+		found := false
+		for _, candidate := range paths {
+			if candidate == p {
+				found = true
+				break
+			}
+		}
+		if !found || (ext != ".html" && ext != ".txt") {
+			http.NotFound(w, r)
+			return
+		}
+
+		email, err := render(fsys, p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if ext == ".html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, email.HTMLBody)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, plainText(email))
+	})
+}
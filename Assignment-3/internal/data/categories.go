@@ -0,0 +1,208 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrDuplicateCategory = errors.New("duplicate category")
+	ErrUnknownCategory   = errors.New("unknown category")
+)
+
+// SlugRX matches the lowercase, hyphen separated names that we use for categories
+// and tags, such as "off-road" or "1-10-scale".
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+type Category struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", validator.Required)
+	v.Check(len(category.Name) <= 50, "name", validator.MaxBytes, 50)
+	v.Check(validator.Matches(category.Name, SlugRX), "name", validator.Slug)
+	v.Check(len(category.Description) <= 1000, "description", validator.MaxBytes, 1000)
+}
+
+// ValidateSlugs checks a list of category or tag names supplied for a remote car. The
+// key is used both as the error key and in the messages.
+func ValidateSlugs(v *validator.Validator, key string, slugs []string, max int) {
+	v.Check(len(slugs) <= max, key, validator.MaxEntries, max)
+	v.Check(validator.Unique(slugs), key, validator.Duplicates)
+	for _, slug := range slugs {
+		v.Check(len(slug) <= 50, key, validator.MaxValueBytes, 50)
+		v.Check(validator.Matches(slug, SlugRX), key, validator.Slug)
+	}
+}
+
+type CategoryModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+		INSERT INTO categories (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := startQuery(m.ctx, "CategoryModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, category.Name, category.Description).Scan(&category.ID, &category.CreatedAt, &category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_name_key"`:
+			return ErrDuplicateCategory
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, description, version
+		FROM categories
+		WHERE id = $1`
+
+	var category Category
+
+	ctx, cancel := startQuery(m.ctx, "CategoryModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&category.ID,
+		&category.CreatedAt,
+		&category.Name,
+		&category.Description,
+		&category.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &category, nil
+}
+
+// GetAll returns every category ordered by name. The list is expected to stay small, so
+// unlike remote cars it isn't paginated.
+func (m CategoryModel) GetAll() ([]*Category, error) {
+	query := `
+		SELECT id, created_at, name, description, version
+		FROM categories
+		ORDER BY name ASC`
+
+	ctx, cancel := startQuery(m.ctx, "CategoryModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+
+	for rows.Next() {
+		var category Category
+
+		err := rows.Scan(
+			&category.ID,
+			&category.CreatedAt,
+			&category.Name,
+			&category.Description,
+			&category.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, &category)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func (m CategoryModel) Update(category *Category) error {
+	query := `
+		UPDATE categories
+		SET name = $1, description = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{
+		category.Name,
+		category.Description,
+		category.ID,
+		category.Version,
+	}
+
+	ctx, cancel := startQuery(m.ctx, "CategoryModel.Update", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_name_key"`:
+			return ErrDuplicateCategory
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM categories
+		WHERE id = $1`
+
+	ctx, cancel := startQuery(m.ctx, "CategoryModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
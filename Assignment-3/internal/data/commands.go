@@ -0,0 +1,219 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The commands that can be sent to a remote car.
+const (
+	CommandStart         = "start"
+	CommandStop          = "stop"
+	CommandLock          = "lock"
+	CommandSetSpeedLimit = "set_speed_limit"
+	CommandLocate        = "locate"
+)
+
+// The states that a command moves through. A command starts out queued, becomes
+// delivered once the car's device has fetched it, and then ends up acknowledged or
+// failed depending on what the device reports back. A command that isn't picked up or
+// answered before it expires ends up as expired instead.
+const (
+	CommandQueued       = "queued"
+	CommandDelivered    = "delivered"
+	CommandAcknowledged = "acknowledged"
+	CommandFailed       = "failed"
+	CommandExpired      = "expired"
+)
+
+var (
+	ErrCommandNotPending = errors.New("command is not awaiting acknowledgement")
+)
+
+type Command struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RemoteCarID int64      `json:"remote_car_id"`
+	UserID      int64      `json:"user_id"`
+	Type        string     `json:"type"`
+	SpeedLimit  *int32     `json:"speed_limit,omitempty"`
+	State       string     `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Version     int32      `json:"version"`
+}
+
+func ValidateCommand(v *validator.Validator, command *Command) {
+	v.Check(command.Type != "", "type", validator.Required)
+	v.Check(validator.In(command.Type, CommandStart, CommandStop, CommandLock, CommandSetSpeedLimit, CommandLocate), "type", validator.OneOf, "start, stop, lock, set_speed_limit, locate")
+
+	if command.Type == CommandSetSpeedLimit {
+		v.Check(command.SpeedLimit != nil, "speed_limit", validator.Required)
+		if command.SpeedLimit != nil {
+			v.Check(*command.SpeedLimit > 0 && *command.SpeedLimit <= 500, "speed_limit", validator.Between, 1, 500)
+		}
+	} else {
+		v.Check(command.SpeedLimit == nil, "speed_limit", validator.OnlyFor, "set_speed_limit")
+	}
+
+	v.Check(command.ExpiresAt.After(time.Now()), "ttl", validator.Positive)
+	v.Check(command.ExpiresAt.Before(time.Now().Add(24*time.Hour)), "ttl", validator.MaxDuration, "24h")
+}
+
+type CommandModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+const commandColumns = `id, created_at, remote_car_id, user_id, type, speed_limit, state, error,
+		expires_at, delivered_at, completed_at, version`
+
+func scanCommand(row interface{ Scan(...interface{}) error }, command *Command) error {
+	return row.Scan(
+		&command.ID,
+		&command.CreatedAt,
+		&command.RemoteCarID,
+		&command.UserID,
+		&command.Type,
+		&command.SpeedLimit,
+		&command.State,
+		&command.Error,
+		&command.ExpiresAt,
+		&command.DeliveredAt,
+		&command.CompletedAt,
+		&command.Version,
+	)
+}
+
+func (m CommandModel) Insert(command *Command) error {
+	query := `
+		INSERT INTO commands (remote_car_id, user_id, type, speed_limit, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, state, version`
+
+	args := []interface{}{command.RemoteCarID, command.UserID, command.Type, command.SpeedLimit, command.ExpiresAt}
+
+	ctx, cancel := startQuery(m.ctx, "CommandModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&command.ID, &command.CreatedAt, &command.State, &command.Version)
+}
+
+// Get returns a command sent to a specific remote car. Commands are always looked up
+// through the car, so an ID belonging to another car is reported as not found.
+func (m CommandModel) Get(remoteCarID, id int64) (*Command, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := startQuery(m.ctx, "CommandModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.expire(ctx, remoteCarID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + commandColumns + `
+		FROM commands
+		WHERE remote_car_id = $1 AND id = $2`
+
+	var command Command
+
+	err = scanCommand(m.DB.QueryRowContext(ctx, query, remoteCarID, id), &command)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &command, nil
+}
+
+// ClaimNext marks the oldest queued command for a remote car as delivered and returns
+// it. SKIP LOCKED means that two polls racing each other never receive the same
+// command. If nothing is queued, ErrRecordNotFound is returned.
+func (m CommandModel) ClaimNext(remoteCarID int64) (*Command, error) {
+	ctx, cancel := startQuery(m.ctx, "CommandModel.ClaimNext", 3*time.Second)
+	defer cancel()
+
+	err := m.expire(ctx, remoteCarID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE commands
+		SET state = 'delivered', delivered_at = NOW(), version = version + 1
+		WHERE id = (
+			SELECT id FROM commands
+			WHERE remote_car_id = $1 AND state = 'queued'
+			ORDER BY id ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + commandColumns
+
+	var command Command
+
+	err = scanCommand(m.DB.QueryRowContext(ctx, query, remoteCarID), &command)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &command, nil
+}
+
+// Complete records the outcome reported by the device for a delivered command. The
+// state must be either CommandAcknowledged or CommandFailed. Commands that have
+// already been completed, or that expired first, return ErrCommandNotPending.
+func (m CommandModel) Complete(command *Command, state, errorMessage string) error {
+	query := `
+		UPDATE commands
+		SET state = $1, error = $2, completed_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4 AND state = 'delivered' AND expires_at > NOW()
+		RETURNING state, error, completed_at, version`
+
+	args := []interface{}{state, errorMessage, command.ID, command.Version}
+
+	ctx, cancel := startQuery(m.ctx, "CommandModel.Complete", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&command.State, &command.Error, &command.CompletedAt, &command.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrCommandNotPending
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// expire moves any command for the remote car that has passed its expiry time without
+// being completed into the expired state. It is called before commands are read, so
+// that clients never see a stale queued or delivered state.
+func (m CommandModel) expire(ctx context.Context, remoteCarID int64) error {
+	query := `
+		UPDATE commands
+		SET state = 'expired', version = version + 1
+		WHERE remote_car_id = $1 AND state IN ('queued', 'delivered') AND expires_at <= NOW()`
+
+	_, err := m.DB.ExecContext(ctx, query, remoteCarID)
+	return err
+}
@@ -0,0 +1,274 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DeviceKeyPrefix is prepended to every device API key. It lets the authenticate()
+// middleware tell a device key apart from a user's authentication token without a
+// database lookup, and makes leaked keys easy to spot.
+const DeviceKeyPrefix = "dk_"
+
+var (
+	ErrDuplicateHardwareID = errors.New("duplicate hardware id")
+)
+
+// Device is the hardware installed in a remote car. It authenticates with an API key
+// instead of an email and password, and is only ever allowed to act on the remote car
+// that it is bound to.
+type Device struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	HardwareID   string    `json:"hardware_id"`
+	RemoteCarID  int64     `json:"remote_car_id"`
+	KeyCreatedAt time.Time `json:"key_created_at"`
+	Version      int32     `json:"version"`
+}
+
+// DeviceKey holds a newly generated API key for a device. Like a Token, only the
+// SHA-256 hash is stored, so the plaintext can be shown to an admin exactly once.
+type DeviceKey struct {
+	Plaintext string `json:"key"`
+	Hash      []byte `json:"-"`
+}
+
+func generateDeviceKey() (*DeviceKey, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &DeviceKey{
+		Plaintext: DeviceKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+	}
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+func ValidateDeviceKeyPlaintext(v *validator.Validator, key string) {
+	v.Check(key != "", "key", validator.Required)
+	v.Check(strings.HasPrefix(key, DeviceKeyPrefix), "key", validator.DeviceKey)
+	v.Check(len(key) == len(DeviceKeyPrefix)+52, "key", validator.ExactBytes, len(DeviceKeyPrefix)+52)
+}
+
+func ValidateDevice(v *validator.Validator, device *Device) {
+	v.Check(device.HardwareID != "", "hardware_id", validator.Required)
+	v.Check(len(device.HardwareID) <= 100, "hardware_id", validator.MaxBytes, 100)
+	v.Check(device.RemoteCarID > 0, "remote_car_id", validator.Required)
+}
+
+type DeviceModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// Insert provisions a new device and returns its first API key.
+func (m DeviceModel) Insert(device *Device) (*DeviceKey, error) {
+	key, err := generateDeviceKey()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO devices (hardware_id, remote_car_id, key_hash)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, key_created_at, version`
+
+	args := []interface{}{device.HardwareID, device.RemoteCarID, key.Hash}
+
+	ctx, cancel := startQuery(m.ctx, "DeviceModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&device.ID, &device.CreatedAt, &device.KeyCreatedAt, &device.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "devices_hardware_id_key"`:
+			return nil, ErrDuplicateHardwareID
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (m DeviceModel) Get(id int64) (*Device, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, hardware_id, remote_car_id, key_created_at, version
+		FROM devices
+		WHERE id = $1`
+
+	var device Device
+
+	ctx, cancel := startQuery(m.ctx, "DeviceModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&device.ID,
+		&device.CreatedAt,
+		&device.HardwareID,
+		&device.RemoteCarID,
+		&device.KeyCreatedAt,
+		&device.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &device, nil
+}
+
+// GetForKey returns the device that owns the given plaintext API key.
+func (m DeviceModel) GetForKey(keyPlaintext string) (*Device, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT id, created_at, hardware_id, remote_car_id, key_created_at, version
+		FROM devices
+		WHERE key_hash = $1`
+
+	var device Device
+
+	ctx, cancel := startQuery(m.ctx, "DeviceModel.GetForKey", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:]).Scan(
+		&device.ID,
+		&device.CreatedAt,
+		&device.HardwareID,
+		&device.RemoteCarID,
+		&device.KeyCreatedAt,
+		&device.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &device, nil
+}
+
+func (m DeviceModel) GetAll() ([]*Device, error) {
+	query := `
+		SELECT id, created_at, hardware_id, remote_car_id, key_created_at, version
+		FROM devices
+		ORDER BY id ASC`
+
+	ctx, cancel := startQuery(m.ctx, "DeviceModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*Device{}
+
+	for rows.Next() {
+		var device Device
+
+		err := rows.Scan(
+			&device.ID,
+			&device.CreatedAt,
+			&device.HardwareID,
+			&device.RemoteCarID,
+			&device.KeyCreatedAt,
+			&device.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, &device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// RotateKey replaces the API key of a device. The old key stops working as soon as the
+// update commits.
+func (m DeviceModel) RotateKey(device *Device) (*DeviceKey, error) {
+	key, err := generateDeviceKey()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE devices
+		SET key_hash = $1, key_created_at = NOW(), version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING key_created_at, version`
+
+	ctx, cancel := startQuery(m.ctx, "DeviceModel.RotateKey", 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, key.Hash, device.ID, device.Version).Scan(&device.KeyCreatedAt, &device.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (m DeviceModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM devices
+		WHERE id = $1`
+
+	ctx, cancel := startQuery(m.ctx, "DeviceModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
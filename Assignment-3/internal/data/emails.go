@@ -0,0 +1,321 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/tracing"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The states of a queued email. An email is pending until it has been sent, or until
+// it has failed too many times, at which point it is dead-lettered and left for an
+// admin to look at and requeue.
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+// How long a claimed email is reserved for the worker that claimed it, which needs to
+// be longer than the mailer's send timeout.
+const emailLease = time.Minute
+
+// Email is a message waiting in, or already sent from, the email outbox. The template
+// data is never included in API responses, because it can hold secrets such as
+// activation tokens; it is also cleared once the email has been sent. Traceparent
+// links the sending of the email to the trace of the request that queued it.
+type Email struct {
+	ID            int64                  `json:"id"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Recipient     string                 `json:"recipient"`
+	Locale        string                 `json:"locale"`
+	Template      string                 `json:"template"`
+	Data          map[string]interface{} `json:"-"`
+	Traceparent   string                 `json:"-"`
+	State         string                 `json:"state"`
+	Attempts      int32                  `json:"attempts"`
+	NextAttemptAt *time.Time             `json:"next_attempt_at,omitempty"`
+	LastError     string                 `json:"last_error,omitempty"`
+	SentAt        *time.Time             `json:"sent_at,omitempty"`
+	Version       int32                  `json:"version"`
+}
+
+type EmailModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// Enqueue adds an email to the outbox, to be sent by the background worker using the
+// version of the template for the given locale. The data is stored as JSON, so it
+// should only hold strings, numbers and booleans.
+func (m EmailModel) Enqueue(recipient, locale, template string, data map[string]interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_outbox (recipient, locale, template, data, traceparent)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.Enqueue", 3*time.Second)
+	defer cancel()
+
+	traceparent := tracing.SpanFromContext(ctx).SpanContext().Traceparent()
+
+	_, err = m.DB.ExecContext(ctx, query, recipient, locale, template, js, traceparent)
+	return err
+}
+
+const emailColumns = `id, created_at, recipient, locale, template, state, attempts,
+		CASE WHEN state = 'pending' THEN next_attempt_at END, last_error, sent_at, version`
+
+func (m EmailModel) Get(id int64) (*Email, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + emailColumns + `
+		FROM email_outbox
+		WHERE id = $1`
+
+	var email Email
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Recipient,
+		&email.Locale,
+		&email.Template,
+		&email.State,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&email.SentAt,
+		&email.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &email, nil
+}
+
+// GetAll returns a page of the emails in the outbox, optionally only those in the
+// given state.
+func (m EmailModel) GetAll(state string, filters Filters) ([]*Email, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM email_outbox
+		WHERE ($1 = '' OR state = $1)
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, emailColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, state, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	emails := []*Email{}
+
+	for rows.Next() {
+		var email Email
+
+		err := rows.Scan(
+			&totalRecords,
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Locale,
+			&email.Template,
+			&email.State,
+			&email.Attempts,
+			&email.NextAttemptAt,
+			&email.LastError,
+			&email.SentAt,
+			&email.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return emails, metadata, nil
+}
+
+// Requeue gives a dead-lettered email a fresh set of attempts, starting straight away.
+func (m EmailModel) Requeue(email *Email) error {
+	query := `
+		UPDATE email_outbox
+		SET state = 'pending', attempts = 0, next_attempt_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2 AND state = 'dead'
+		RETURNING state, attempts, next_attempt_at, version`
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.Requeue", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email.ID, email.Version).Scan(
+		&email.State,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClaimDue reserves up to limit pending emails that are due to be sent, oldest first.
+// SKIP LOCKED lets several API instances work through the outbox together without
+// sending the same email twice.
+func (m EmailModel) ClaimDue(limit int) ([]*Email, error) {
+	query := `
+		UPDATE email_outbox
+		SET next_attempt_at = NOW() + $1 * interval '1 second'
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE state = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, recipient, locale, template, data, traceparent, state, attempts, version`
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.ClaimDue", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, emailLease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+
+	for rows.Next() {
+		var (
+			email Email
+			data  []byte
+		)
+
+		err := rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Locale,
+			&email.Template,
+			&data,
+			&email.Traceparent,
+			&email.State,
+			&email.Attempts,
+			&email.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(data, &email.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that an email was sent and clears its template data.
+func (m EmailModel) MarkSent(email *Email) error {
+	query := `
+		UPDATE email_outbox
+		SET state = 'sent', attempts = attempts + 1, sent_at = NOW(), last_error = '',
+			data = '{}', version = version + 1
+		WHERE id = $1`
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.MarkSent", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email.ID)
+	return err
+}
+
+// RecordFailure records a failed attempt to send an email. The email is retried with
+// exponential backoff (one minute, doubling with each attempt up to an hour) until
+// maxAttempts have been made, and is then dead-lettered.
+func (m EmailModel) RecordFailure(email *Email, sendErr error, maxAttempts int) error {
+	attempts := email.Attempts + 1
+
+	state := EmailPending
+	if int(attempts) >= maxAttempts {
+		state = EmailDead
+	}
+
+	backoff := time.Minute << (attempts - 1)
+	if backoff > time.Hour || backoff <= 0 {
+		backoff = time.Hour
+	}
+
+	query := `
+		UPDATE email_outbox
+		SET state = $1, attempts = $2, next_attempt_at = $3, last_error = $4, version = version + 1
+		WHERE id = $5`
+
+	args := []interface{}{state, attempts, time.Now().Add(backoff), sendErr.Error(), email.ID}
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.RecordFailure", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteSent removes sent emails once they are older than the given age. Dead emails
+// are kept until they have been dealt with.
+func (m EmailModel) DeleteSent(age time.Duration) error {
+	query := `
+		DELETE FROM email_outbox
+		WHERE state = 'sent' AND sent_at < $1`
+
+	ctx, cancel := startQuery(m.ctx, "EmailModel.DeleteSent", 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	return err
+}
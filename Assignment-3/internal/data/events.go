@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EventsChannel is the PostgreSQL NOTIFY channel that the record_event() trigger
+// publishes each new event on, as the JSON encoding of the events row.
+const EventsChannel = "events"
+
+// The resources that events are recorded for.
+const (
+	ResourceRemoteCar = "remote_car"
+)
+
+// The actions that an event can describe.
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// EventRetention is how long events are kept for clients that reconnect with a
+// Last-Event-ID. Older events are deleted by DeleteExpired().
+const EventRetention = 24 * time.Hour
+
+// Event records that a resource was created, updated or deleted. Events are written by
+// database triggers, so they are recorded no matter which code path changed the row.
+// They deliberately don't carry the resource itself: clients that care about the
+// change fetch the latest version from the normal endpoint.
+type Event struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Resource   string    `json:"resource"`
+	Action     string    `json:"action"`
+	ResourceID int64     `json:"resource_id"`
+}
+
+type EventModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// GetAfter returns up to limit events that may have been missed by a client that last
+// saw the event with the given ID, oldest first.
+//
+// IDs are handed out when events are inserted, but transactions commit in their own
+// order, so an event can become visible after one with a higher ID has been sent. To
+// catch those, GetAfter also returns the events created up to overlap before the given
+// one, which the caller has most likely seen already and should skip by ID. If the
+// given event has been deleted, only the events with higher IDs are returned.
+func (m EventModel) GetAfter(id int64, overlap time.Duration, limit int) ([]*Event, error) {
+	query := `
+		SELECT id, created_at, resource, action, resource_id
+		FROM events
+		WHERE id > $1
+		OR created_at >= (SELECT created_at FROM events WHERE id = $1) - $2 * interval '1 second'
+		ORDER BY id ASC
+		LIMIT $3`
+
+	ctx, cancel := startQuery(m.ctx, "EventModel.GetAfter", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, overlap.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Resource,
+			&event.Action,
+			&event.ResourceID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// LatestID returns the ID of the most recent event, or zero if there are none.
+func (m EventModel) LatestID() (int64, error) {
+	query := `
+		SELECT COALESCE(max(id), 0)
+		FROM events`
+
+	ctx, cancel := startQuery(m.ctx, "EventModel.LatestID", 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+// DeleteExpired removes events older than EventRetention.
+func (m EventModel) DeleteExpired() error {
+	query := `
+		DELETE FROM events
+		WHERE created_at < $1`
+
+	ctx, cancel := startQuery(m.ctx, "EventModel.DeleteExpired", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-EventRetention))
+	return err
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+type FavoriteModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// Add bookmarks a remote car for a user. Adding a car that is already a favorite is
+// not an error, which keeps the PUT endpoint idempotent.
+func (m FavoriteModel) Add(userID, remoteCarID int64) error {
+	query := `
+		INSERT INTO favorites (user_id, remote_car_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := startQuery(m.ctx, "FavoriteModel.Add", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, remoteCarID)
+	return err
+}
+
+func (m FavoriteModel) Remove(userID, remoteCarID int64) error {
+	query := `
+		DELETE FROM favorites
+		WHERE user_id = $1 AND remote_car_id = $2`
+
+	ctx, cancel := startQuery(m.ctx, "FavoriteModel.Remove", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, remoteCarID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Favorited reports which of the given remote car IDs the user has bookmarked. IDs
+// that aren't favorites are simply absent from the returned map.
+func (m FavoriteModel) Favorited(userID int64, remoteCarIDs []int64) (map[int64]bool, error) {
+	favorited := make(map[int64]bool)
+
+	if len(remoteCarIDs) == 0 {
+		return favorited, nil
+	}
+
+	query := `
+		SELECT remote_car_id
+		FROM favorites
+		WHERE user_id = $1 AND remote_car_id = ANY($2)`
+
+	ctx, cancel := startQuery(m.ctx, "FavoriteModel.Favorited", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(remoteCarIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		favorited[id] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return favorited, nil
+}
+
+// GetAllForUser returns a page of the remote cars that a user has bookmarked, using
+// the same columns, sorting and pagination as RemoteCarsModel.GetAll().
+func (m FavoriteModel) GetAllForUser(userID int64, filters Filters) ([]*RemoteCars, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), remote_cars.id, remote_cars.created_at, remote_cars.name,
+			remote_cars.year, remote_cars.cost, remote_cars.description,
+			remote_cars.latitude, remote_cars.longitude,
+			%s, %s,
+			%s AS rating, %s, remote_cars.version
+		FROM remote_cars
+		INNER JOIN favorites ON favorites.remote_car_id = remote_cars.id
+		WHERE favorites.user_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, categoriesColumn, tagsColumn, ratingColumn, reviewCountColumn, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := startQuery(m.ctx, "FavoriteModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	remotecars := []*RemoteCars{}
+
+	for rows.Next() {
+		var (
+			remotecar           RemoteCars
+			latitude, longitude sql.NullFloat64
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&remotecar.ID,
+			&remotecar.CreatedAt,
+			&remotecar.Name,
+			&remotecar.Year,
+			&remotecar.Cost,
+			&remotecar.Description,
+			&latitude,
+			&longitude,
+			pq.Array(&remotecar.Categories),
+			pq.Array(&remotecar.Tags),
+			&remotecar.Rating,
+			&remotecar.ReviewCount,
+			&remotecar.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		remotecar.Location = newLocation(latitude, longitude)
+
+		remotecars = append(remotecars, &remotecar)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return remotecars, metadata, nil
+}
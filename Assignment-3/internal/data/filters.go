@@ -38,20 +38,21 @@ func (f Filters) offset() int {
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.Page > 0, "page", validator.GreaterThanZero)
+	v.Check(f.Page <= 10_000_000, "page", validator.Max, 10_000_000)
+	v.Check(f.PageSize > 0, "page_size", validator.GreaterThanZero)
+	v.Check(f.PageSize <= 100, "page_size", validator.Max, 100)
 
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", validator.InvalidSort)
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int            `json:"current_page,omitempty"`
+	PageSize     int            `json:"page_size,omitempty"`
+	FirstPage    int            `json:"first_page,omitempty"`
+	LastPage     int            `json:"last_page,omitempty"`
+	TotalRecords int            `json:"total_records,omitempty"`
+	TagCounts    map[string]int `json:"tag_counts,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// LiveChannel is the PostgreSQL NOTIFY channel for the live view of a remote car. The
+// commands table has a trigger that publishes every change to a command, and
+// TelemetryModel.Insert() publishes the latest reading of each upload.
+const LiveChannel = "remote_car_live"
+
+// The kinds of LiveUpdate.
+const (
+	LiveTelemetry = "telemetry"
+	LiveCommand   = "command"
+)
+
+// LiveUpdate is the payload sent on LiveChannel. Exactly one of Telemetry and Command
+// is set, depending on the type.
+type LiveUpdate struct {
+	RemoteCarID int64             `json:"remote_car_id"`
+	Type        string            `json:"type"`
+	Telemetry   *TelemetryReading `json:"telemetry,omitempty"`
+	Command     *Command          `json:"command,omitempty"`
+}
+
+// notifyLive publishes a live update as part of the transaction, so that it is only
+// delivered if the transaction commits.
+func notifyLive(ctx context.Context, tx *sql.Tx, update *LiveUpdate) error {
+	js, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, LiveChannel, string(js))
+	return err
+}
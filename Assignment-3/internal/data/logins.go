@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// LoginPolicy decides how failed logins are slowed down for a key, such as an email
+// address or an IP address.
+type LoginPolicy struct {
+	// Once a key has DelayAfter failures, each further attempt has to wait, for one
+	// second at first and doubling with every failure up to MaxDelay.
+	DelayAfter int
+	MaxDelay   time.Duration
+	// Once a key has LockoutAfter failures, it is locked out for the Lockout period,
+	// and its count of failures starts again.
+	LockoutAfter int
+	Lockout      time.Duration
+	// Failures are forgotten once there have been none for the Window.
+	Window time.Duration
+}
+
+// block returns how long a key with the given number of failures is blocked for, and
+// whether that is a lockout.
+func (p LoginPolicy) block(failures int) (time.Duration, bool) {
+	if failures >= p.LockoutAfter {
+		return p.Lockout, true
+	}
+	if failures < p.DelayAfter {
+		return 0, false
+	}
+
+	delay := time.Second << (failures - p.DelayAfter)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return delay, false
+}
+
+// LoginFailureModel tracks failed logins, so that password guessing can be slowed down
+// and eventually locked out. The keys are tracked whether or not they belong to an
+// account, so that the responses don't reveal which email addresses do.
+type LoginFailureModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// Blocked returns how long it is until any of the keys may try to log in again, or 0 if
+// none of them are blocked.
+func (m LoginFailureModel) Blocked(keys ...string) (time.Duration, error) {
+	query := `
+		SELECT COALESCE(EXTRACT(EPOCH FROM MAX(blocked_until) - NOW()), 0)::double precision
+		FROM login_failures
+		WHERE key = ANY($1)`
+
+	ctx, cancel := startQuery(m.ctx, "LoginFailureModel.Blocked", 3*time.Second)
+	defer cancel()
+
+	var seconds float64
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	if seconds <= 0 {
+		return 0, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// RecordFailure counts a failed login for the key and blocks it as the policy says. It
+// reports whether the key has just been locked out.
+func (m LoginFailureModel) RecordFailure(key string, policy LoginPolicy) (bool, error) {
+	query := `
+		INSERT INTO login_failures (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_failures.last_failure_at < NOW() - $2 * interval '1 second' THEN 1
+				ELSE login_failures.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures`
+
+	ctx, cancel := startQuery(m.ctx, "LoginFailureModel.RecordFailure", 3*time.Second)
+	defer cancel()
+
+	var failures int
+
+	err := m.DB.QueryRowContext(ctx, query, key, policy.Window.Seconds()).Scan(&failures)
+	if err != nil {
+		return false, err
+	}
+
+	delay, lockedOut := policy.block(failures)
+	if delay == 0 {
+		return false, nil
+	}
+
+	query = `
+		UPDATE login_failures
+		SET blocked_until = GREATEST(blocked_until, NOW() + $2 * interval '1 second'),
+			failures = CASE WHEN $3 THEN 0 ELSE failures END
+		WHERE key = $1`
+
+	_, err = m.DB.ExecContext(ctx, query, key, delay.Seconds(), lockedOut)
+	if err != nil {
+		return false, err
+	}
+
+	return lockedOut, nil
+}
+
+// Reset forgets the failed logins for a key, after a successful login.
+func (m LoginFailureModel) Reset(key string) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE key = $1`
+
+	ctx, cancel := startQuery(m.ctx, "LoginFailureModel.Reset", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteExpired removes the keys whose failures have been forgotten and that aren't
+// blocked.
+func (m LoginFailureModel) DeleteExpired(window time.Duration) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE last_failure_at < NOW() - $1 * interval '1 second' AND blocked_until < NOW()`
+
+	ctx, cancel := startQuery(m.ctx, "LoginFailureModel.DeleteExpired", 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, window.Seconds())
+	return err
+}
@@ -12,16 +12,40 @@ var (
 
 type Models struct {
 	RemoteCars  RemoteCarsModel
+	Categories  CategoryModel
+	Commands    CommandModel
+	Devices     DeviceModel
+	Emails      EmailModel
+	Events      EventModel
+	Favorites   FavoriteModel
+	Logins      LoginFailureModel
 	Users       UserModel
 	Permissions PermissionModel
+	Prices      PriceModel
+	Reviews     ReviewModel
+	Telemetry   TelemetryModel
 	Tokens      TokenModel
+	TwoFactor   TwoFactorModel
+	Webhooks    WebhookModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
 		RemoteCars:  RemoteCarsModel{DB: db},
+		Categories:  CategoryModel{DB: db},
+		Commands:    CommandModel{DB: db},
+		Devices:     DeviceModel{DB: db},
+		Emails:      EmailModel{DB: db},
+		Events:      EventModel{DB: db},
+		Favorites:   FavoriteModel{DB: db},
+		Logins:      LoginFailureModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Prices:      PriceModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Telemetry:   TelemetryModel{DB: db},
 		Tokens:      TokenModel{DB: db},
+		TwoFactor:   TwoFactorModel{DB: db},
 		Users:       UserModel{DB: db},
+		Webhooks:    WebhookModel{DB: db},
 	}
 }
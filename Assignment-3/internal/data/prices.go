@@ -0,0 +1,231 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PriceChange is a single entry in the price history of a remote car. The first entry
+// for every car is its original price, which has no PreviousCost.
+type PriceChange struct {
+	ID           int64     `json:"id"`
+	RemoteCarID  int64     `json:"remote_car_id"`
+	Cost         Cost      `json:"cost"`
+	PreviousCost *Cost     `json:"previous_cost,omitempty"`
+	ChangedAt    time.Time `json:"changed_at"`
+}
+
+// PriceAlert is a user's request to be emailed once the cost of a remote car drops
+// below Threshold.
+type PriceAlert struct {
+	UserID      int64     `json:"-"`
+	RemoteCarID int64     `json:"remote_car_id"`
+	Threshold   Cost      `json:"threshold"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func ValidatePriceAlert(v *validator.Validator, alert *PriceAlert) {
+	v.Check(alert.Threshold != 0, "threshold", validator.Required)
+	v.Check(alert.Threshold > 0, "threshold", validator.PositiveInteger)
+}
+
+// PriceDropRecipient is a user who should be told about a price drop, along with the
+// threshold that triggered it (zero when they are only watching the car as a
+// favorite).
+type PriceDropRecipient struct {
+	UserID    int64
+	Name      string
+	Email     string
+	Locale    string
+	Threshold Cost
+}
+
+type PriceModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// recordPriceChange appends an entry to the price history inside the transaction that
+// changed the price. previousCost is nil for a newly inserted remote car.
+func recordPriceChange(ctx context.Context, tx *sql.Tx, remoteCarID int64, cost Cost, previousCost *Cost) error {
+	query := `
+		INSERT INTO price_history (remote_car_id, cost, previous_cost)
+		VALUES ($1, $2, $3)`
+
+	_, err := tx.ExecContext(ctx, query, remoteCarID, cost, previousCost)
+	return err
+}
+
+func (m PriceModel) GetHistory(remoteCarID int64, filters Filters) ([]*PriceChange, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, remote_car_id, cost, previous_cost, changed_at
+		FROM price_history
+		WHERE remote_car_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := startQuery(m.ctx, "PriceModel.GetHistory", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, remoteCarID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	changes := []*PriceChange{}
+
+	for rows.Next() {
+		var change PriceChange
+
+		err := rows.Scan(
+			&totalRecords,
+			&change.ID,
+			&change.RemoteCarID,
+			&change.Cost,
+			&change.PreviousCost,
+			&change.ChangedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return changes, metadata, nil
+}
+
+// SetAlert creates a price alert for the user, or replaces the threshold of the one
+// they already have for the same remote car.
+func (m PriceModel) SetAlert(alert *PriceAlert) error {
+	query := `
+		INSERT INTO price_alerts (user_id, remote_car_id, threshold)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, remote_car_id) DO UPDATE SET threshold = EXCLUDED.threshold
+		RETURNING created_at`
+
+	ctx, cancel := startQuery(m.ctx, "PriceModel.SetAlert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, alert.UserID, alert.RemoteCarID, alert.Threshold).Scan(&alert.CreatedAt)
+}
+
+func (m PriceModel) DeleteAlert(userID, remoteCarID int64) error {
+	query := `
+		DELETE FROM price_alerts
+		WHERE user_id = $1 AND remote_car_id = $2`
+
+	ctx, cancel := startQuery(m.ctx, "PriceModel.DeleteAlert", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, remoteCarID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m PriceModel) GetAlertsForUser(userID int64) ([]*PriceAlert, error) {
+	query := `
+		SELECT user_id, remote_car_id, threshold, created_at
+		FROM price_alerts
+		WHERE user_id = $1
+		ORDER BY created_at DESC, remote_car_id ASC`
+
+	ctx, cancel := startQuery(m.ctx, "PriceModel.GetAlertsForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*PriceAlert{}
+
+	for rows.Next() {
+		var alert PriceAlert
+
+		err := rows.Scan(&alert.UserID, &alert.RemoteCarID, &alert.Threshold, &alert.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		alerts = append(alerts, &alert)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// GetDropRecipients returns the activated users who should be emailed about a remote
+// car's price falling from previousCost to cost. Users with a price alert are notified
+// only when the drop crosses their threshold, so they hear about it once rather than
+// on every subsequent reduction. Users who favorited the car without setting an alert
+// are notified of any drop.
+func (m PriceModel) GetDropRecipients(remoteCarID int64, previousCost, cost Cost) ([]*PriceDropRecipient, error) {
+	query := `
+		SELECT users.id, users.name, users.email, users.locale, COALESCE(price_alerts.threshold, 0)
+		FROM users
+		LEFT JOIN price_alerts ON price_alerts.user_id = users.id AND price_alerts.remote_car_id = $1
+		WHERE users.activated
+		AND (
+			(price_alerts.threshold > $3 AND price_alerts.threshold <= $2)
+			OR (price_alerts.threshold IS NULL AND EXISTS (
+				SELECT 1 FROM favorites
+				WHERE favorites.user_id = users.id AND favorites.remote_car_id = $1
+			))
+		)`
+
+	ctx, cancel := startQuery(m.ctx, "PriceModel.GetDropRecipients", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, remoteCarID, previousCost, cost)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []*PriceDropRecipient{}
+
+	for rows.Next() {
+		var recipient PriceDropRecipient
+
+		err := rows.Scan(&recipient.UserID, &recipient.Name, &recipient.Email, &recipient.Locale, &recipient.Threshold)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, &recipient)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
@@ -6,9 +6,43 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"time"
 )
 
+const (
+	MatchAny = "any"
+	MatchAll = "all"
+)
+
+// Location is a point on the earth's surface, in decimal degrees.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// newLocation builds a Location from nullable latitude and longitude columns, returning
+// nil for remote cars that don't have a location.
+func newLocation(latitude, longitude sql.NullFloat64) *Location {
+	if !latitude.Valid || !longitude.Valid {
+		return nil
+	}
+	return &Location{Latitude: latitude.Float64, Longitude: longitude.Float64}
+}
+
+// latitudeLongitude returns the two values to store for a possibly nil location.
+func (l *Location) latitudeLongitude() (latitude, longitude *float64) {
+	if l == nil {
+		return nil, nil
+	}
+	return &l.Latitude, &l.Longitude
+}
+
+func ValidateLocation(v *validator.Validator, key string, location *Location) {
+	v.Check(location.Latitude >= -90 && location.Latitude <= 90, key, validator.FieldBetween, "latitude", -90, 90)
+	v.Check(location.Longitude >= -180 && location.Longitude <= 180, key, validator.FieldBetween, "longitude", -180, 180)
+}
+
 type RemoteCars struct {
 	ID          int64     `json:"id"`
 	CreatedAt   time.Time `json:"-"`
@@ -16,34 +50,75 @@ type RemoteCars struct {
 	Year        int32     `json:"year,omitempty"`
 	Cost        Cost      `json:"cost,omitempty"`
 	Description string    `json:"description,omitempty"`
+	Categories  []string  `json:"categories,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Location    *Location `json:"location,omitempty"`
+	Distance    *float64  `json:"distance,omitempty"`
+	Rating      float64   `json:"rating,omitempty"`
+	ReviewCount int32     `json:"review_count,omitempty"`
+	IsFavorite  *bool     `json:"is_favorite,omitempty"`
 	Version     int32     `json:"version"`
 }
 
 func ValidateRemoteCars(v *validator.Validator, remotecars *RemoteCars) {
-	v.Check(remotecars.Name != "", "name", "must be provided")
-	v.Check(len(remotecars.Name) <= 500, "name", "must not be more than 500 bytes long")
-	v.Check(remotecars.Year != 0, "year", "must be provided")
-	v.Check(remotecars.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(remotecars.Cost != 0, "cost", "must be provided")
-	v.Check(remotecars.Cost > 0, "cost", "must be a positive integer")
+	v.Check(remotecars.Name != "", "name", validator.Required)
+	v.Check(len(remotecars.Name) <= 500, "name", validator.MaxBytes, 500)
+	v.Check(remotecars.Year != 0, "year", validator.Required)
+	v.Check(remotecars.Year <= int32(time.Now().Year()), "year", validator.NotInFuture)
+	v.Check(remotecars.Cost != 0, "cost", validator.Required)
+	v.Check(remotecars.Cost > 0, "cost", validator.PositiveInteger)
+	ValidateSlugs(v, "categories", remotecars.Categories, 5)
+	ValidateSlugs(v, "tags", remotecars.Tags, 20)
+	if remotecars.Location != nil {
+		ValidateLocation(v, "location", remotecars.Location)
+	}
 }
 
 type RemoteCarsModel struct {
-	DB *sql.DB
+	DB  *sql.DB
+	ctx context.Context
 }
 
 func (m RemoteCarsModel) Insert(remotecars *RemoteCars) error {
 	query := `
-		INSERT INTO remote_cars (name, year, cost, description)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO remote_cars (name, year, cost, description, latitude, longitude)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, version`
 
-	args := []interface{}{remotecars.Name, remotecars.Year, remotecars.Cost, remotecars.Description}
+	latitude, longitude := remotecars.Location.latitudeLongitude()
+
+	args := []interface{}{remotecars.Name, remotecars.Year, remotecars.Cost, remotecars.Description, latitude, longitude}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "RemoteCarsModel.Insert", 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&remotecars.ID, &remotecars.CreatedAt, &remotecars.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&remotecars.ID, &remotecars.CreatedAt, &remotecars.Version)
+	if err != nil {
+		return err
+	}
+
+	err = recordPriceChange(ctx, tx, remotecars.ID, remotecars.Cost, nil)
+	if err != nil {
+		return err
+	}
+
+	err = setCategories(ctx, tx, remotecars.ID, remotecars.Categories)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(ctx, tx, remotecars.ID, remotecars.Tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m RemoteCarsModel) Get(id int64) (*RemoteCars, error) {
@@ -52,13 +127,18 @@ func (m RemoteCarsModel) Get(id int64) (*RemoteCars, error) {
 	}
 
 	query := `
-		SELECT id, created_at, name, year, cost, description, version
+		SELECT id, created_at, name, year, cost, description, latitude, longitude,
+			` + categoriesColumn + `, ` + tagsColumn + `,
+			` + ratingColumn + `, ` + reviewCountColumn + `, version
 		FROM remote_cars
 		WHERE id = $1`
 
-	var remotecars RemoteCars
+	var (
+		remotecars          RemoteCars
+		latitude, longitude sql.NullFloat64
+	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "RemoteCarsModel.Get", 3*time.Second)
 
 	defer cancel()
 
@@ -69,6 +149,12 @@ func (m RemoteCarsModel) Get(id int64) (*RemoteCars, error) {
 		&remotecars.Year,
 		&remotecars.Cost,
 		&remotecars.Description,
+		&latitude,
+		&longitude,
+		pq.Array(&remotecars.Categories),
+		pq.Array(&remotecars.Tags),
+		&remotecars.Rating,
+		&remotecars.ReviewCount,
 		&remotecars.Version,
 	)
 
@@ -81,29 +167,49 @@ func (m RemoteCarsModel) Get(id int64) (*RemoteCars, error) {
 		}
 	}
 
+	remotecars.Location = newLocation(latitude, longitude)
+
 	return &remotecars, nil
 }
 
+// Update saves the changes to a remote car, using the version number to guard against
+// edit conflicts. The UPDATE joins remote_cars against itself so that RETURNING can
+// give us the cost as it was before the change; if the cost differs, the change is
+// appended to the price history in the same transaction.
 func (m RemoteCarsModel) Update(remotecars *RemoteCars) error {
 	query := `
 		UPDATE remote_cars
-		SET name = $1, year = $2, cost = $3, description = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version`
+		SET name = $1, year = $2, cost = $3, description = $4, latitude = $5, longitude = $6,
+			version = remote_cars.version + 1
+		FROM remote_cars AS previous
+		WHERE remote_cars.id = $7 AND remote_cars.version = $8 AND previous.id = remote_cars.id
+		RETURNING remote_cars.version, previous.cost`
+
+	latitude, longitude := remotecars.Location.latitudeLongitude()
 
 	args := []interface{}{
 		remotecars.Name,
 		remotecars.Year,
 		remotecars.Cost,
 		remotecars.Description,
+		latitude,
+		longitude,
 		remotecars.ID,
 		remotecars.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "RemoteCarsModel.Update", 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&remotecars.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previousCost Cost
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&remotecars.Version, &previousCost)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -112,7 +218,25 @@ func (m RemoteCarsModel) Update(remotecars *RemoteCars) error {
 			return err
 		}
 	}
-	return nil
+
+	if previousCost != remotecars.Cost {
+		err = recordPriceChange(ctx, tx, remotecars.ID, remotecars.Cost, &previousCost)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setCategories(ctx, tx, remotecars.ID, remotecars.Categories)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(ctx, tx, remotecars.ID, remotecars.Tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m RemoteCarsModel) Delete(id int64) error {
@@ -124,7 +248,7 @@ func (m RemoteCarsModel) Delete(id int64) error {
 		DELETE FROM remote_cars
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "RemoteCarsModel.Delete", 3*time.Second)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -144,18 +268,33 @@ func (m RemoteCarsModel) Delete(id int64) error {
 	return nil
 }
 
-func (m RemoteCarsModel) GetAll(name string, filters Filters) ([]*RemoteCars, Metadata, error) {
+// GetAll returns a page of remote cars matching the name search and the category and
+// tag filters. With match set to MatchAny a car needs at least one of the requested
+// categories (and at least one of the requested tags); with MatchAll it needs every
+// one of them. When near is not nil, only cars within radius kilometres of it are
+// returned, each with its distance filled in. The returned Metadata also carries tag
+// counts for the whole filtered result set, not just the current page, so clients can
+// render facets.
+func (m RemoteCarsModel) GetAll(name string, categories []string, tags []string, match string, near *Location, radius float64, filters Filters) ([]*RemoteCars, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT  count(*) OVER(), id, created_at, name, year, cost, description, version
+		SELECT  count(*) OVER(), id, created_at, name, year, cost, description, latitude, longitude,
+			%s, %s,
+			%s AS rating, %s, %s AS distance, version
 		FROM remote_cars
-		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		WHERE %s
 		ORDER BY %s %s, id ASC
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $8 OFFSET $9`, categoriesColumn, tagsColumn, ratingColumn, reviewCountColumn, distanceColumn, remoteCarsFilterClause, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "RemoteCarsModel.GetAll", 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{name, filters.limit(), filters.offset()}
+	var nearRadius *float64
+	nearLatitude, nearLongitude := near.latitudeLongitude()
+	if near != nil {
+		nearRadius = &radius
+	}
+
+	args := []interface{}{name, pq.Array(categories), pq.Array(tags), match, nearLatitude, nearLongitude, nearRadius, filters.limit(), filters.offset()}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -168,7 +307,10 @@ func (m RemoteCarsModel) GetAll(name string, filters Filters) ([]*RemoteCars, Me
 	remotecars := []*RemoteCars{}
 
 	for rows.Next() {
-		var remotecar RemoteCars
+		var (
+			remotecar           RemoteCars
+			latitude, longitude sql.NullFloat64
+		)
 
 		err := rows.Scan(
 			&totalRecords,
@@ -178,12 +320,21 @@ func (m RemoteCarsModel) GetAll(name string, filters Filters) ([]*RemoteCars, Me
 			&remotecar.Year,
 			&remotecar.Cost,
 			&remotecar.Description,
+			&latitude,
+			&longitude,
+			pq.Array(&remotecar.Categories),
+			pq.Array(&remotecar.Tags),
+			&remotecar.Rating,
+			&remotecar.ReviewCount,
+			&remotecar.Distance,
 			&remotecar.Version,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		remotecar.Location = newLocation(latitude, longitude)
+
 		remotecars = append(remotecars, &remotecar)
 	}
 
@@ -193,6 +344,168 @@ func (m RemoteCarsModel) GetAll(name string, filters Filters) ([]*RemoteCars, Me
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
+	if totalRecords > 0 {
+		metadata.TagCounts, err = m.tagCounts(ctx, args[:7]...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
 	return remotecars, metadata, nil
 
 }
+
+// tagCounts counts how many remote cars matching the list filters carry each tag. It
+// takes the first seven arguments of the GetAll() query so the two stay in sync.
+func (m RemoteCarsModel) tagCounts(ctx context.Context, args ...interface{}) (map[string]int, error) {
+	query := fmt.Sprintf(`
+		SELECT tags.name, count(*)
+		FROM tags
+		INNER JOIN remote_cars_tags ON remote_cars_tags.tag_id = tags.id
+		WHERE remote_cars_tags.remote_car_id IN (SELECT id FROM remote_cars WHERE %s)
+		GROUP BY tags.name`, remoteCarsFilterClause)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+
+	for rows.Next() {
+		var (
+			tag   string
+			count int
+		)
+
+		err := rows.Scan(&tag, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[tag] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// The categories and tags of a remote car are selected as sorted text arrays, so they
+// can be scanned straight into the RemoteCars struct with pq.Array().
+const (
+	categoriesColumn = `ARRAY(
+			SELECT categories.name FROM categories
+			INNER JOIN remote_cars_categories ON remote_cars_categories.category_id = categories.id
+			WHERE remote_cars_categories.remote_car_id = remote_cars.id
+			ORDER BY categories.name)`
+	tagsColumn = `ARRAY(
+			SELECT tags.name FROM tags
+			INNER JOIN remote_cars_tags ON remote_cars_tags.tag_id = tags.id
+			WHERE remote_cars_tags.remote_car_id = remote_cars.id
+			ORDER BY tags.name)`
+)
+
+// The average rating (rounded to two decimal places) and number of reviews for a remote
+// car. Cars without any reviews get a rating of zero, which also means that they sort
+// last when listing by -rating.
+const (
+	ratingColumn      = `(SELECT COALESCE(round(avg(reviews.rating), 2), 0) FROM reviews WHERE reviews.remote_car_id = remote_cars.id)`
+	reviewCountColumn = `(SELECT count(*) FROM reviews WHERE reviews.remote_car_id = remote_cars.id)`
+)
+
+// distanceColumn is the great-circle distance in kilometres between a remote car and
+// the point given as $5 (latitude) and $6 (longitude), using the haversine formula so
+// that we don't depend on PostGIS. It is NULL when no point is given or the car has no
+// location. The argument to asin() is capped at 1 to guard against rounding errors for
+// antipodal points.
+const distanceColumn = `(6371 * 2 * asin(least(1, sqrt(
+			power(sin(radians(latitude - $5::double precision) / 2), 2) +
+			cos(radians($5::double precision)) * cos(radians(latitude)) *
+			power(sin(radians(longitude - $6::double precision) / 2), 2)))))`
+
+// remoteCarsFilterClause is shared by GetAll() and tagCounts(). It expects the name
+// as $1, the categories as $2, the tags as $3, the match mode as $4 and the optional
+// near point and radius as $5, $6 and $7. An empty category or tag list disables that
+// filter; otherwise the number of distinct matches must reach one (for "any") or the
+// length of the list (for "all"). The latitude range check in the radius filter is
+// cheap to evaluate with the index and discards most cars before the distance is
+// calculated (one degree of latitude is about 111 km everywhere).
+const remoteCarsFilterClause = `
+		(to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (cardinality($2::text[]) = 0 OR (
+			SELECT count(DISTINCT categories.name) FROM categories
+			INNER JOIN remote_cars_categories ON remote_cars_categories.category_id = categories.id
+			WHERE remote_cars_categories.remote_car_id = remote_cars.id AND categories.name = ANY($2)
+		) >= CASE WHEN $4 = 'all' THEN cardinality($2::text[]) ELSE 1 END)
+		AND (cardinality($3::text[]) = 0 OR (
+			SELECT count(DISTINCT tags.name) FROM tags
+			INNER JOIN remote_cars_tags ON remote_cars_tags.tag_id = tags.id
+			WHERE remote_cars_tags.remote_car_id = remote_cars.id AND tags.name = ANY($3)
+		) >= CASE WHEN $4 = 'all' THEN cardinality($3::text[]) ELSE 1 END)
+		AND ($7::double precision IS NULL OR (
+			latitude BETWEEN $5 - $7 / 111.0 AND $5 + $7 / 111.0
+			AND ` + distanceColumn + ` <= $7
+		))`
+
+// setCategories replaces the categories of a remote car inside the given transaction.
+// Categories are managed separately by admins, so any name that doesn't exist results
+// in ErrUnknownCategory rather than being created on the fly.
+func setCategories(ctx context.Context, tx *sql.Tx, id int64, categories []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM remote_cars_categories WHERE remote_car_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if len(categories) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO remote_cars_categories (remote_car_id, category_id)
+		SELECT $1, categories.id FROM categories WHERE categories.name = ANY($2)`
+
+	result, err := tx.ExecContext(ctx, query, id, pq.Array(categories))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(categories)) {
+		return ErrUnknownCategory
+	}
+
+	return nil
+}
+
+// setTags replaces the tags of a remote car inside the given transaction. Tags are
+// free-form, so any tag that doesn't exist yet is created first.
+func setTags(ctx context.Context, tx *sql.Tx, id int64, tags []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM remote_cars_tags WHERE remote_car_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO remote_cars_tags (remote_car_id, tag_id)
+		SELECT $1, tags.id FROM tags WHERE tags.name = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, id, pq.Array(tags))
+	return err
+}
@@ -0,0 +1,207 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+type Review struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserID      int64     `json:"user_id"`
+	UserName    string    `json:"user_name"`
+	RemoteCarID int64     `json:"remote_car_id"`
+	Rating      int32     `json:"rating"`
+	Body        string    `json:"body,omitempty"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", validator.Required)
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", validator.Between, 1, 5)
+	v.Check(len(review.Body) <= 5000, "body", validator.MaxBytes, 5000)
+}
+
+type ReviewModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (user_id, remote_car_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{review.UserID, review.RemoteCarID, review.Rating, review.Body}
+
+	ctx, cancel := startQuery(m.ctx, "ReviewModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_user_id_remote_car_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetForUser returns the review that a specific user left for a remote car. Each user
+// can review a car at most once, so this is how we look up the review to update or
+// delete.
+func (m ReviewModel) GetForUser(remoteCarID, userID int64) (*Review, error) {
+	query := `
+		SELECT reviews.id, reviews.created_at, reviews.user_id, users.name, reviews.remote_car_id,
+			reviews.rating, reviews.body, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.remote_car_id = $1 AND reviews.user_id = $2`
+
+	var review Review
+
+	ctx, cancel := startQuery(m.ctx, "ReviewModel.GetForUser", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, remoteCarID, userID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UserID,
+		&review.UserName,
+		&review.RemoteCarID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+func (m ReviewModel) GetAllForRemoteCar(remoteCarID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), reviews.id, reviews.created_at, reviews.user_id, users.name,
+			reviews.remote_car_id, reviews.rating, reviews.body, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.remote_car_id = $1
+		ORDER BY reviews.%s %s, reviews.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := startQuery(m.ctx, "ReviewModel.GetAllForRemoteCar", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, remoteCarID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UserID,
+			&review.UserName,
+			&review.RemoteCarID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{
+		review.Rating,
+		review.Body,
+		review.ID,
+		review.Version,
+	}
+
+	ctx, cancel := startQuery(m.ctx, "ReviewModel.Update", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1`
+
+	ctx, cancel := startQuery(m.ctx, "ReviewModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
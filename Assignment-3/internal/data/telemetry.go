@@ -0,0 +1,230 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// MaxTelemetryBatch is the largest number of readings accepted in a single upload.
+	MaxTelemetryBatch = 1000
+	// MaxTelemetryAgeDays is how far back a reading may have been recorded. A car that
+	// was offline uploads what it buffered once it reconnects, but the readings can't
+	// be arbitrarily old, since each month they cover gets a partition created for it.
+	MaxTelemetryAgeDays = 30
+)
+
+// TelemetryReading is a single report from the hardware in a remote car. Everything
+// apart from the timestamp is optional, as not every car has a GPS module and a car may
+// skip a sensor that failed to respond.
+type TelemetryReading struct {
+	RecordedAt     time.Time `json:"recorded_at"`
+	BatteryLevel   *float64  `json:"battery_level,omitempty"`
+	SignalStrength *int32    `json:"signal_strength,omitempty"`
+	Speed          *float64  `json:"speed,omitempty"`
+	Latitude       *float64  `json:"latitude,omitempty"`
+	Longitude      *float64  `json:"longitude,omitempty"`
+}
+
+// TelemetryAggregate summarises the readings that fall into one bucket of a downsampled
+// telemetry series. The position is the last one reported within the bucket.
+type TelemetryAggregate struct {
+	Time              time.Time `json:"time"`
+	Readings          int       `json:"readings"`
+	BatteryLevelAvg   *float64  `json:"battery_level_avg,omitempty"`
+	BatteryLevelMin   *float64  `json:"battery_level_min,omitempty"`
+	SignalStrengthAvg *float64  `json:"signal_strength_avg,omitempty"`
+	SpeedAvg          *float64  `json:"speed_avg,omitempty"`
+	SpeedMax          *float64  `json:"speed_max,omitempty"`
+	Latitude          *float64  `json:"latitude,omitempty"`
+	Longitude         *float64  `json:"longitude,omitempty"`
+}
+
+func ValidateTelemetryReading(v *validator.Validator, reading *TelemetryReading) {
+	v.Check(!reading.RecordedAt.IsZero(), "recorded_at", validator.Required)
+	v.Check(reading.RecordedAt.Before(time.Now().Add(time.Minute)), "recorded_at", validator.NotInFuture)
+	v.Check(reading.RecordedAt.After(time.Now().AddDate(0, 0, -MaxTelemetryAgeDays)), "recorded_at", validator.MaxAgeDays, MaxTelemetryAgeDays)
+
+	if reading.BatteryLevel != nil {
+		v.Check(*reading.BatteryLevel >= 0 && *reading.BatteryLevel <= 100, "battery_level", validator.Between, 0, 100)
+	}
+	if reading.SignalStrength != nil {
+		v.Check(*reading.SignalStrength >= -150 && *reading.SignalStrength <= 0, "signal_strength", validator.Between, -150, 0)
+	}
+	if reading.Speed != nil {
+		v.Check(*reading.Speed >= 0 && *reading.Speed <= 500, "speed", validator.Between, 0, 500)
+	}
+
+	v.Check((reading.Latitude == nil) == (reading.Longitude == nil), "latitude", validator.RequiredWith, "longitude")
+	if reading.Latitude != nil {
+		v.Check(*reading.Latitude >= -90 && *reading.Latitude <= 90, "latitude", validator.Between, -90, 90)
+	}
+	if reading.Longitude != nil {
+		v.Check(*reading.Longitude >= -180 && *reading.Longitude <= 180, "longitude", validator.Between, -180, 180)
+	}
+}
+
+type TelemetryModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// Insert stores a batch of readings for a remote car in a single transaction. The
+// telemetry table is partitioned by month, and the partitions are created on demand
+// for whichever months the batch covers; as ValidateTelemetryReading() refuses
+// readings older than MaxTelemetryAgeDays, that is never more than three. Readings
+// that duplicate an existing timestamp for the car are ignored, so a device can safely
+// retry an upload. The batch must not be empty.
+func (m TelemetryModel) Insert(remoteCarID int64, readings []*TelemetryReading) error {
+	ctx, cancel := startQuery(m.ctx, "TelemetryModel.Insert", 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	months := make(map[time.Time]bool)
+	for _, reading := range readings {
+		recordedAt := reading.RecordedAt.UTC()
+		months[time.Date(recordedAt.Year(), recordedAt.Month(), 1, 0, 0, 0, 0, time.UTC)] = true
+	}
+
+	for month := range months {
+		err = createTelemetryPartition(ctx, tx, month)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO telemetry (remote_car_id, recorded_at, battery_level, signal_strength, speed, latitude, longitude)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT DO NOTHING`
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, reading := range readings {
+		args := []interface{}{
+			remoteCarID,
+			reading.RecordedAt,
+			reading.BatteryLevel,
+			reading.SignalStrength,
+			reading.Speed,
+			reading.Latitude,
+			reading.Longitude,
+		}
+
+		_, err = stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Live views only need to know where the car is now, so only the most recent
+	// reading in the batch is published.
+	latest := readings[0]
+	for _, reading := range readings[1:] {
+		if reading.RecordedAt.After(latest.RecordedAt) {
+			latest = reading
+		}
+	}
+
+	err = notifyLive(ctx, tx, &LiveUpdate{RemoteCarID: remoteCarID, Type: LiveTelemetry, Telemetry: latest})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// createTelemetryPartition makes sure that the partition holding the given month
+// exists. Concurrent uploads may try to create the same missing partition, so in that
+// case the DDL is serialized with a transaction-level advisory lock.
+func createTelemetryPartition(ctx context.Context, tx *sql.Tx, month time.Time) error {
+	name := "telemetry_" + month.Format("2006_01")
+
+	var exists bool
+
+	err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('telemetry_partitions'))`)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s PARTITION OF telemetry
+		FOR VALUES FROM ('%s') TO ('%s')`,
+		name, month.Format(time.RFC3339), month.AddDate(0, 1, 0).Format(time.RFC3339))
+
+	_, err = tx.ExecContext(ctx, query)
+	return err
+}
+
+// GetAggregates downsamples the readings for a remote car between from (inclusive) and
+// to (exclusive) into buckets of the given resolution. Buckets without any readings are
+// left out rather than being filled with nulls.
+func (m TelemetryModel) GetAggregates(remoteCarID int64, from, to time.Time, resolution time.Duration) ([]*TelemetryAggregate, error) {
+	query := `
+		SELECT to_timestamp(floor(extract(epoch FROM recorded_at) / $4) * $4) AS bucket,
+			count(*),
+			avg(battery_level), min(battery_level),
+			avg(signal_strength),
+			avg(speed), max(speed),
+			(array_agg(latitude ORDER BY recorded_at DESC) FILTER (WHERE latitude IS NOT NULL))[1],
+			(array_agg(longitude ORDER BY recorded_at DESC) FILTER (WHERE longitude IS NOT NULL))[1]
+		FROM telemetry
+		WHERE remote_car_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		GROUP BY bucket
+		ORDER BY bucket ASC`
+
+	ctx, cancel := startQuery(m.ctx, "TelemetryModel.GetAggregates", 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, remoteCarID, from, to, resolution.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aggregates := []*TelemetryAggregate{}
+
+	for rows.Next() {
+		var aggregate TelemetryAggregate
+
+		err := rows.Scan(
+			&aggregate.Time,
+			&aggregate.Readings,
+			&aggregate.BatteryLevelAvg,
+			&aggregate.BatteryLevelMin,
+			&aggregate.SignalStrengthAvg,
+			&aggregate.SpeedAvg,
+			&aggregate.SpeedMax,
+			&aggregate.Latitude,
+			&aggregate.Longitude,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		aggregates = append(aggregates, &aggregate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return aggregates, nil
+}
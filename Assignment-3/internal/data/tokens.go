@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"strings"
 	"time"
 )
 
@@ -15,8 +16,23 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeDevice         = "device"
+	// A user with two-factor authentication enabled gets a 2fa-pending token when they
+	// log in with their password. It can only be exchanged, along with a code, for an
+	// authentication token.
+	ScopeTwoFactorPending = "2fa-pending"
 )
 
+// BearerScope reports which scope a token sent in an Authorization header belongs to.
+// Device keys carry the DeviceKeyPrefix; anything else is treated as a user's
+// authentication token.
+func BearerScope(tokenPlaintext string) string {
+	if strings.HasPrefix(tokenPlaintext, DeviceKeyPrefix) {
+		return ScopeDevice
+	}
+	return ScopeAuthentication
+}
+
 // Define a Token struct to hold the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expiry time and
 // scope.
@@ -65,13 +81,14 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.Check(tokenPlaintext != "", "token", validator.Required)
+	v.Check(len(tokenPlaintext) == 26, "token", validator.ExactBytes, 26)
 }
 
 // Define the TokenModel type.
 type TokenModel struct {
-	DB *sql.DB
+	DB  *sql.DB
+	ctx context.Context
 }
 
 // The New() method is a shortcut which creates a new Token struct and then inserts the
@@ -89,7 +106,7 @@ func (m TokenModel) Insert(token *Token) error {
 INSERT INTO tokens (hash, user_id, expiry, scope)
 VALUES ($1, $2, $3, $4)`
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "TokenModel.Insert", 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
@@ -100,7 +117,7 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 DELETE FROM tokens
 WHERE scope = $1 AND user_id = $2`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "TokenModel.DeleteAllForUser", 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/tracing"
+	"context"
+	"time"
+)
+
+// startQuery returns the context for the database calls made by a model method, which
+// times out after the given duration. If the models were bound to a trace with
+// Models.WithTrace(), it also starts a span named after the method; the returned
+// cancel function ends it.
+func startQuery(parent context.Context, name string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, span := tracing.Start(parent, name, tracing.SpanKindClient,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("code.function", name),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// WithTrace returns a copy of the models whose database calls are recorded as children
+// of the span in ctx. Only the span is taken from ctx, not its deadline or
+// cancellation, so queries still run to completion if, for example, the client that
+// made the request goes away.
+func (m Models) WithTrace(ctx context.Context) Models {
+	span := tracing.SpanFromContext(ctx)
+	if span == nil {
+		return m
+	}
+
+	ctx = tracing.ContextWithSpan(context.Background(), span)
+
+	m.RemoteCars.ctx = ctx
+	m.Categories.ctx = ctx
+	m.Commands.ctx = ctx
+	m.Devices.ctx = ctx
+	m.Emails.ctx = ctx
+	m.Events.ctx = ctx
+	m.Favorites.ctx = ctx
+	m.Logins.ctx = ctx
+	m.Users.ctx = ctx
+	m.Permissions.ctx = ctx
+	m.Prices.ctx = ctx
+	m.Reviews.ctx = ctx
+	m.Telemetry.ctx = ctx
+	m.Tokens.ctx = ctx
+	m.TwoFactor.ctx = ctx
+	m.Webhooks.ctx = ctx
+
+	return m
+}
@@ -0,0 +1,29 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/tracing"
+	"context"
+	"reflect"
+	"testing"
+)
+
+// WithTrace has to set ctx on every model by hand, so this checks that a model added
+// to Models later isn't missed, which would silently leave its queries out of traces.
+func TestModelsWithTrace(t *testing.T) {
+	ctx := tracing.ContextWithSpan(context.Background(), new(tracing.Span))
+
+	models := reflect.ValueOf(NewModels(nil).WithTrace(ctx))
+
+	for i := 0; i < models.NumField(); i++ {
+		name := models.Type().Field(i).Name
+
+		field := models.Field(i).FieldByName("ctx")
+		if !field.IsValid() {
+			t.Errorf("Models.%s has no ctx field", name)
+			continue
+		}
+		if field.IsNil() {
+			t.Errorf("WithTrace doesn't set Models.%s.ctx", name)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is the number of recovery codes given out when two-factor
+// authentication is enabled.
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor is a user's TOTP secret. It is only in use once ConfirmedAt is set.
+type TwoFactor struct {
+	UserID       int64
+	Secret       []byte
+	CreatedAt    time.Time
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+// Enabled reports whether the secret has been confirmed.
+func (tf *TwoFactor) Enabled() bool {
+	return tf.ConfirmedAt != nil
+}
+
+// TwoFactorModel stores users' TOTP secrets and recovery codes. The recovery codes are
+// stored as SHA-256 hashes, like tokens, since they are random rather than chosen by
+// the user.
+type TwoFactorModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// Get returns the user's TOTP secret, confirmed or not.
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, created_at, confirmed_at, last_used_step
+		FROM two_factor
+		WHERE user_id = $1`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.Get", 3*time.Second)
+	defer cancel()
+
+	var tf TwoFactor
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.CreatedAt,
+		&tf.ConfirmedAt,
+		&tf.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tf, nil
+}
+
+// Enabled reports whether the user has two-factor authentication enabled.
+func (m TwoFactorModel) Enabled(userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM two_factor WHERE user_id = $1 AND confirmed_at IS NOT NULL
+		)`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.Enabled", 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Enroll saves a new, unconfirmed secret for the user, replacing any earlier one that
+// was never confirmed. It returns ErrTwoFactorEnabled if the user already has a
+// confirmed secret, which has to be removed with Delete first.
+func (m TwoFactorModel) Enroll(userID int64, secret []byte) error {
+	query := `
+		INSERT INTO two_factor (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE two_factor.confirmed_at IS NULL`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.Enroll", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// Confirm enables the user's secret, once they have sent a code for the given step,
+// and returns a new set of recovery codes. The plaintext codes are only available
+// here. It returns ErrRecordNotFound if there is no unconfirmed secret.
+func (m TwoFactorModel) Confirm(userID int64, step int64) ([]string, error) {
+	query := `
+		UPDATE two_factor
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.Confirm", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO two_factor_recovery_codes (hash, user_id)
+			VALUES ($1, $2)`, hashRecoveryCode(codes[i]), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseStep records that a code for the given step has been accepted. It returns false
+// if a code for that step, or a later one, has been accepted already, in which case
+// the code being checked is a replay and must be refused.
+func (m TwoFactorModel) UseStep(userID int64, step int64) (bool, error) {
+	query := `
+		UPDATE two_factor
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.UseStep", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes. It returns false if the
+// code isn't one of theirs or has been used before.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		UPDATE two_factor_recovery_codes
+		SET used_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.UseRecoveryCode", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// RemainingRecoveryCodes returns the number of the user's recovery codes that haven't
+// been used.
+func (m TwoFactorModel) RemainingRecoveryCodes(userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM two_factor_recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL`
+
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.RemainingRecoveryCodes", 3*time.Second)
+	defer cancel()
+
+	var remaining int
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&remaining)
+	return remaining, err
+}
+
+// Delete turns two-factor authentication off for the user, removing their secret and
+// recovery codes.
+func (m TwoFactorModel) Delete(userID int64) error {
+	ctx, cancel := startQuery(m.ctx, "TwoFactorModel.Delete", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// generateRecoveryCode returns a random code of 50 bits, written as two groups of five
+// characters, such as "k3vq7-2mxaw", to make it easier to copy down.
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 10)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(randomBytes))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes, so that it
+// matches however the user types it in.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
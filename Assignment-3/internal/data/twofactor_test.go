@@ -0,0 +1,164 @@
+package data
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("k3vq72mxaw")
+
+	for _, code := range []string{"k3vq7-2mxaw", "K3VQ7-2MXAW", "k3vq7 2mxaw", " K3vq-7 2Mx-aw "} {
+		if got := hashRecoveryCode(code); !bytes.Equal(got, want) {
+			t.Errorf("hashRecoveryCode(%q) doesn't match the plain code", code)
+		}
+	}
+
+	if bytes.Equal(hashRecoveryCode("k3vq7-2mxax"), want) {
+		t.Error("a different code has the same hash")
+	}
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Errorf("got %q; want two groups of five base 32 characters", code)
+		}
+		if seen[code] {
+			t.Errorf("got %q twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+// The TwoFactorModel tests need a database with the migrations applied, given by the
+// DATA_TEST_DB_DSN environment variable, and are skipped without one.
+func newTestTwoFactor(t *testing.T) (TwoFactorModel, int64) {
+	t.Helper()
+
+	dsn := os.Getenv("DATA_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("DATA_TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every test uses a user of its own, which is removed afterwards along with its
+	// secret and recovery codes.
+	user := &User{
+		Name:   "Two Factor Test",
+		Email:  fmt.Sprintf("2fa-test-%d@example.com", time.Now().UnixNano()),
+		Locale: "en",
+	}
+	err = user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UserModel{DB: db}.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_, err := db.Exec(`DELETE FROM users WHERE id = $1`, user.ID)
+		if err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	return TwoFactorModel{DB: db}, user.ID
+}
+
+func TestTwoFactorModelUseStep(t *testing.T) {
+	m, userID := newTestTwoFactor(t)
+
+	err := m.Enroll(userID, []byte("12345678901234567890"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Confirm(userID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		step int64
+		want bool
+	}{
+		{100, false}, // the step the secret was confirmed with
+		{99, false},
+		{101, true},
+		{101, false}, // a replay
+		{100, false},
+		{103, true},
+		{102, false}, // earlier than the last one accepted
+	}
+
+	for i, tt := range tests {
+		got, err := m.UseStep(userID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("use %d, step %d: got %t; want %t", i+1, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestTwoFactorModelUseRecoveryCode(t *testing.T) {
+	m, userID := newTestTwoFactor(t)
+
+	err := m.Enroll(userID, []byte("12345678901234567890"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := m.Confirm(userID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(codes), RecoveryCodeCount)
+	}
+
+	for i, tt := range []struct {
+		code string
+		want bool
+	}{
+		{codes[0], true},
+		{codes[0], false},
+		{"aaaaa-aaaaa", false},
+		{codes[1], true},
+	} {
+		got, err := m.UseRecoveryCode(userID, tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("use %d: got %t; want %t", i+1, got, tt.want)
+		}
+	}
+
+	remaining, err := m.RemainingRecoveryCodes(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != RecoveryCodeCount-2 {
+		t.Errorf("got %d remaining recovery codes; want %d", remaining, RecoveryCodeCount-2)
+	}
+}
@@ -1,12 +1,14 @@
 package data
 
 import (
+	"assignment3.yerniyaz.net/internal/i18n"
 	"assignment3.yerniyaz.net/internal/validator"
 	"context"
 	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"time"
 )
 
@@ -25,6 +27,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Locale    string    `json:"locale"`
 	Version   int       `json:"-"`
 }
 
@@ -72,18 +75,39 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// dummyPasswordHash is a bcrypt hash, with the same cost as the real ones, of a random
+// password that has since been thrown away.
+var dummyPasswordHash = []byte("$2a$12$GmSJsT28PE1oxvqbF9YKCuluEGTmO15f1E1Lz3qhjiWiXLvOntEFq")
+
+// CheckDummyPassword takes as long as checking a real password, and never matches. It
+// is used when someone tries to log in with an email address that doesn't belong to
+// anyone, so that the response takes as long as for a wrong password and doesn't give
+// away which email addresses have accounts.
+func CheckDummyPassword(plaintextPassword string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(email != "", "email", validator.Required)
+	v.Check(validator.Matches(email, validator.EmailRX), "email", validator.InvalidEmail)
 }
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(password != "", "password", validator.Required)
+	v.Check(len(password) >= 8, "password", validator.MinBytes, 8)
+	v.Check(len(password) <= 72, "password", validator.MaxBytes, 72)
 }
+
+// ValidateLocale checks that a locale is one that emails and API messages are
+// translated into.
+func ValidateLocale(v *validator.Validator, locale string) {
+	v.Check(locale != "", "locale", validator.Required)
+	v.Check(locale == "" || i18n.Supported(locale), "locale", validator.OneOf, strings.Join(i18n.Locales, ", "))
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(user.Name != "", "name", validator.Required)
+	v.Check(len(user.Name) <= 500, "name", validator.MaxBytes, 500)
+	ValidateLocale(v, user.Locale)
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
 	// If the plaintext password is not nil, call the standalone
@@ -102,7 +126,8 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB  *sql.DB
+	ctx context.Context
 }
 
 // Insert a new record in the database for the user. Note that the id, created_at and
@@ -111,11 +136,11 @@ type UserModel struct {
 // that we did when creating a movie.
 func (m UserModel) Insert(user *User) error {
 	query := `
-INSERT INTO users (name, email, password_hash, activated)
-VALUES ($1, $2, $3, $4)
+INSERT INTO users (name, email, password_hash, activated, locale)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING id, created_at, version`
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.Locale}
+	ctx, cancel := startQuery(m.ctx, "UserModel.Insert", 3*time.Second)
 	defer cancel()
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
@@ -138,11 +163,11 @@ RETURNING id, created_at, version`
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, password_hash, activated, locale, version
 FROM users
 WHERE email = $1`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "UserModel.GetByEmail", 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -151,6 +176,7 @@ WHERE email = $1`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -172,25 +198,25 @@ WHERE email = $1`
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET name = $1, email = $2, password_hash = $3, activated = $4, locale = $5, version = version + 1
+WHERE id = $6 AND version = $7
 RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "UserModel.Update", 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
-			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -206,7 +232,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.locale, users.version
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
@@ -219,7 +245,7 @@ AND tokens.expiry > $3`
 	// value to check against the token expiry.
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := startQuery(m.ctx, "UserModel.GetForToken", 3*time.Second)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
@@ -230,6 +256,7 @@ AND tokens.expiry > $3`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -0,0 +1,594 @@
+package data
+
+import (
+	"assignment3.yerniyaz.net/internal/validator"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebhookEventTypes lists the event types that a webhook can subscribe to. They are
+// recorded in the webhook_outbox table by triggers, in the same transaction as the
+// change itself.
+//
+// TODO: add the booking events once there is a bookings table to trigger them.
+var WebhookEventTypes = []string{
+	ResourceRemoteCar + "." + ActionCreated,
+	ResourceRemoteCar + "." + ActionUpdated,
+	ResourceRemoteCar + "." + ActionDeleted,
+}
+
+// The states of a webhook delivery. A delivery stays pending while it is being retried,
+// and ends up failed once MaxWebhookAttempts have been made without success.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// MaxWebhookAttempts is the number of times a delivery is attempted before giving up.
+// With the backoff used by RecordAttempt() the last attempt is made about 12 hours
+// after the first.
+const MaxWebhookAttempts = 12
+
+// How long a claimed delivery is reserved for the dispatcher that claimed it. If that
+// dispatcher dies mid-request the delivery is picked up again once this has passed.
+const webhookLease = time.Minute
+
+type Webhook struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	Version    int32     `json:"version"`
+}
+
+// WebhookDelivery tracks sending one outbox event to one webhook.
+type WebhookDelivery struct {
+	ID            int64             `json:"id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	WebhookID     int64             `json:"webhook_id"`
+	EventID       int64             `json:"event_id"`
+	EventType     string            `json:"event_type"`
+	State         string            `json:"state"`
+	Attempts      int32             `json:"attempts"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	Log           []*WebhookAttempt `json:"log,omitempty"`
+	Version       int32             `json:"version"`
+}
+
+// WebhookAttempt is the delivery log entry for a single HTTP request to a webhook.
+// ResponseStatus is nil when no response was received at all.
+type WebhookAttempt struct {
+	AttemptedAt    time.Time `json:"attempted_at"`
+	ResponseStatus *int      `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Duration       int64     `json:"duration_ms"`
+}
+
+// PendingWebhookDelivery is a delivery claimed by the dispatcher, together with
+// everything needed to send it.
+type PendingWebhookDelivery struct {
+	ID         int64
+	Attempts   int32
+	URL        string
+	Secret     string
+	EventID    int64
+	EventType  string
+	OccurredAt time.Time
+	Data       json.RawMessage
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", validator.Required)
+	v.Check(len(webhook.URL) <= 2000, "url", validator.MaxBytes, 2000)
+
+	if u, err := url.Parse(webhook.URL); err != nil || u.Host == "" {
+		v.AddError("url", validator.AbsoluteURL)
+	} else {
+		v.Check(validator.In(u.Scheme, "http", "https"), "url", validator.HTTPScheme)
+	}
+
+	v.Check(len(webhook.Secret) >= 16, "secret", validator.MinBytes, 16)
+	v.Check(len(webhook.Secret) <= 200, "secret", validator.MaxBytes, 200)
+
+	v.Check(webhook.EventTypes != nil, "event_types", validator.Required)
+	v.Check(len(webhook.EventTypes) >= 1, "event_types", validator.NotEmpty)
+	v.Check(validator.Unique(webhook.EventTypes), "event_types", validator.Duplicates)
+	for _, eventType := range webhook.EventTypes {
+		v.Check(validator.In(eventType, WebhookEventTypes...), "event_types", validator.OnlyContain, strings.Join(WebhookEventTypes, ", "))
+	}
+}
+
+type WebhookModel struct {
+	DB  *sql.DB
+	ctx context.Context
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, event_types, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{webhook.URL, webhook.Secret, pq.Array(webhook.EventTypes), webhook.Active}
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, url, secret, event_types, active, version
+		FROM webhooks
+		WHERE id = $1`
+
+	var webhook Webhook
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.EventTypes),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, secret, event_types, active, version
+		FROM webhooks
+		ORDER BY id ASC`
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.EventTypes),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, secret = $2, event_types = $3, active = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []interface{}{
+		webhook.URL,
+		webhook.Secret,
+		pq.Array(webhook.EventTypes),
+		webhook.Active,
+		webhook.ID,
+		webhook.Version,
+	}
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.Update", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+const webhookDeliveryColumns = `webhook_deliveries.id, webhook_deliveries.created_at,
+		webhook_deliveries.webhook_id, webhook_deliveries.outbox_id, webhook_outbox.event_type,
+		webhook_deliveries.state, webhook_deliveries.attempts,
+		CASE WHEN webhook_deliveries.state = 'pending' THEN webhook_deliveries.next_attempt_at END,
+		webhook_deliveries.version`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, delivery *WebhookDelivery) error {
+	return row.Scan(
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&delivery.State,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.Version,
+	)
+}
+
+// GetDeliveries returns a page of the deliveries made to a webhook, optionally only
+// those in the given state. The attempt log isn't included.
+func (m WebhookModel) GetDeliveries(webhookID int64, state string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM webhook_deliveries
+		INNER JOIN webhook_outbox ON webhook_outbox.id = webhook_deliveries.outbox_id
+		WHERE webhook_deliveries.webhook_id = $1 AND ($2 = '' OR webhook_deliveries.state = $2)
+		ORDER BY webhook_deliveries.%s %s, webhook_deliveries.id ASC
+		LIMIT $3 OFFSET $4`, webhookDeliveryColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.GetDeliveries", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, state, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.State,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// GetDelivery returns a delivery made to a webhook, including its attempt log.
+func (m WebhookModel) GetDelivery(webhookID, id int64) (*WebhookDelivery, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		INNER JOIN webhook_outbox ON webhook_outbox.id = webhook_deliveries.outbox_id
+		WHERE webhook_deliveries.webhook_id = $1 AND webhook_deliveries.id = $2`
+
+	var delivery WebhookDelivery
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.GetDelivery", 3*time.Second)
+	defer cancel()
+
+	err := scanWebhookDelivery(m.DB.QueryRowContext(ctx, query, webhookID, id), &delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT attempted_at, response_status, error, duration_ms
+		FROM webhook_attempts
+		WHERE delivery_id = $1
+		ORDER BY id ASC`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery.Log = []*WebhookAttempt{}
+
+	for rows.Next() {
+		var attempt WebhookAttempt
+
+		err := rows.Scan(&attempt.AttemptedAt, &attempt.ResponseStatus, &attempt.Error, &attempt.Duration)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Log = append(delivery.Log, &attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// Replay puts a delivery back in the queue to be sent straight away, with a fresh set
+// of attempts. It works for deliveries in any state, so a partner can also ask for an
+// event they already received to be sent again. The attempt log is kept.
+func (m WebhookModel) Replay(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET state = 'pending', attempts = 0, next_attempt_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING state, attempts, next_attempt_at, version`
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.Replay", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, delivery.ID, delivery.Version).Scan(
+		&delivery.State,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fanout creates a pending delivery for every active webhook subscribed to each outbox
+// event that hasn't been dispatched yet, and marks those events as dispatched. SKIP
+// LOCKED lets several API instances share the work.
+func (m WebhookModel) Fanout() error {
+	query := `
+		WITH events AS (
+			UPDATE webhook_outbox
+			SET dispatched_at = NOW()
+			WHERE id IN (
+				SELECT id FROM webhook_outbox
+				WHERE dispatched_at IS NULL
+				ORDER BY id ASC
+				LIMIT 100
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, event_type
+		)
+		INSERT INTO webhook_deliveries (webhook_id, outbox_id)
+		SELECT webhooks.id, events.id
+		FROM events
+		INNER JOIN webhooks ON webhooks.active AND events.event_type = ANY(webhooks.event_types)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.Fanout", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
+
+// ClaimDue reserves up to limit deliveries whose next attempt is due, oldest first,
+// by pushing their next attempt back by the lease time.
+func (m WebhookModel) ClaimDue(limit int) ([]*PendingWebhookDelivery, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries
+			SET next_attempt_at = NOW() + $1 * interval '1 second'
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE state = 'pending' AND next_attempt_at <= NOW()
+				ORDER BY next_attempt_at ASC
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, attempts, webhook_id, outbox_id
+		)
+		SELECT claimed.id, claimed.attempts, webhooks.url, webhooks.secret,
+			webhook_outbox.id, webhook_outbox.event_type, webhook_outbox.created_at, webhook_outbox.data
+		FROM claimed
+		INNER JOIN webhooks ON webhooks.id = claimed.webhook_id
+		INNER JOIN webhook_outbox ON webhook_outbox.id = claimed.outbox_id`
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.ClaimDue", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookLease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*PendingWebhookDelivery{}
+
+	for rows.Next() {
+		var delivery PendingWebhookDelivery
+
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.Attempts,
+			&delivery.URL,
+			&delivery.Secret,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.OccurredAt,
+			&delivery.Data,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt logs an attempt to send a delivery and moves the delivery on: to
+// succeeded if the webhook accepted it, to failed if that was the last attempt, or
+// otherwise back to pending with exponential backoff (30 seconds, doubling with each
+// attempt up to a maximum of 4 hours).
+func (m WebhookModel) RecordAttempt(delivery *PendingWebhookDelivery, attempt *WebhookAttempt, succeeded bool) error {
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.RecordAttempt", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_attempts (delivery_id, attempted_at, response_status, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, query, delivery.ID, attempt.AttemptedAt, attempt.ResponseStatus, attempt.Error, attempt.Duration)
+	if err != nil {
+		return err
+	}
+
+	attempts := delivery.Attempts + 1
+	state, backoff := nextDeliveryState(attempts, succeeded)
+
+	query = `
+		UPDATE webhook_deliveries
+		SET state = $1, attempts = $2, next_attempt_at = $3, version = version + 1
+		WHERE id = $4`
+
+	_, err = tx.ExecContext(ctx, query, state, attempts, time.Now().Add(backoff), delivery.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nextDeliveryState returns the state a delivery moves to after its attempts-th
+// attempt, and how long to wait before the next one if it is still pending.
+func nextDeliveryState(attempts int32, succeeded bool) (string, time.Duration) {
+	state := DeliveryPending
+	switch {
+	case succeeded:
+		state = DeliverySucceeded
+	case attempts >= MaxWebhookAttempts:
+		state = DeliveryFailed
+	}
+
+	backoff := 30 * time.Second << (attempts - 1)
+	if backoff > 4*time.Hour || backoff <= 0 {
+		backoff = 4 * time.Hour
+	}
+
+	return state, backoff
+}
+
+// DeleteOldEvents removes outbox events, along with their deliveries and attempt logs,
+// once they are older than the given age.
+func (m WebhookModel) DeleteOldEvents(age time.Duration) error {
+	query := `
+		DELETE FROM webhook_outbox
+		WHERE created_at < $1`
+
+	ctx, cancel := startQuery(m.ctx, "WebhookModel.DeleteOldEvents", 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	return err
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDeliveryState(t *testing.T) {
+	backoffs := []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		16 * time.Minute,
+		32 * time.Minute,
+		64 * time.Minute,
+		128 * time.Minute,
+		4 * time.Hour,
+		4 * time.Hour,
+	}
+
+	// Each failed attempt before the last leaves the delivery pending, with the wait
+	// doubling each time until it reaches the cap.
+	for i, want := range backoffs {
+		attempts := int32(i + 1)
+
+		state, backoff := nextDeliveryState(attempts, false)
+		if state != DeliveryPending {
+			t.Errorf("attempt %d failed: got state %q; want %q", attempts, state, DeliveryPending)
+		}
+		if backoff != want {
+			t.Errorf("attempt %d failed: got backoff %s; want %s", attempts, backoff, want)
+		}
+	}
+
+	if len(backoffs) != MaxWebhookAttempts-1 {
+		t.Fatalf("the schedule covers %d attempts; MaxWebhookAttempts is %d", len(backoffs)+1, MaxWebhookAttempts)
+	}
+
+	state, _ := nextDeliveryState(MaxWebhookAttempts, false)
+	if state != DeliveryFailed {
+		t.Errorf("last attempt failed: got state %q; want %q", state, DeliveryFailed)
+	}
+
+	// A success is final, including on the last attempt.
+	for _, attempts := range []int32{1, 5, MaxWebhookAttempts} {
+		state, _ := nextDeliveryState(attempts, true)
+		if state != DeliverySucceeded {
+			t.Errorf("attempt %d succeeded: got state %q; want %q", attempts, state, DeliverySucceeded)
+		}
+	}
+
+	// The backoff doesn't overflow however many attempts there have been.
+	if _, backoff := nextDeliveryState(100, false); backoff != 4*time.Hour {
+		t.Errorf("attempt 100 failed: got backoff %s; want 4h", backoff)
+	}
+}
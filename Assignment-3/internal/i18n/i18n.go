@@ -0,0 +1,109 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The locales that the API is translated into. English is the default, and the text
+// that is used whenever a translation is missing.
+const (
+	English = "en"
+	Russian = "ru"
+	Kazakh  = "kk"
+
+	Default = English
+)
+
+// Locales lists the supported locales in order of preference.
+var Locales = []string{English, Russian, Kazakh}
+
+// Supported returns true if the locale is one that the API is translated into.
+func Supported(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate picks the supported locale that best matches an Accept-Language header
+// value such as "ru-RU,ru;q=0.9,en;q=0.8". Region subtags are ignored, so "kk-KZ"
+// matches "kk". The default locale is returned if the header is empty or nothing in it
+// is supported.
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+
+	var candidates []candidate
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if base == "*" {
+			base = Default
+		}
+		if Supported(base) {
+			candidates = append(candidates, candidate{locale: base, q: q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Default
+	}
+
+	// A stable sort keeps the client's own order for languages with the same weight.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	return candidates[0].locale
+}
+
+// Catalog holds translated messages, keyed by a stable message code and then by
+// locale. The messages are fmt format strings.
+type Catalog map[string]map[string]string
+
+// Translate returns the message for the code in the given locale, formatted with args.
+// It falls back to the English message, and then to the code itself.
+func (c Catalog) Translate(locale, code string, args ...interface{}) string {
+	translations, ok := c[code]
+	if !ok {
+		return code
+	}
+
+	format, ok := translations[locale]
+	if !ok {
+		format, ok = translations[Default]
+		if !ok {
+			return code
+		}
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,64 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{"empty", "", English},
+		{"garbage", "!!!,;;=", English},
+		{"unsupported", "de-DE, fr;q=0.9", English},
+		{"single", "ru", Russian},
+		{"upper case", "RU", Russian},
+		{"region subtag", "kk-KZ", Kazakh},
+		{"region subtag with weights", "ru-RU,ru;q=0.9,en;q=0.8", Russian},
+		{"q weights", "en;q=0.5, kk;q=0.8, ru;q=0.7", Kazakh},
+		{"weights before unsupported", "de, ru;q=0.2", Russian},
+		{"spaces", " ru ; q=0.4 , kk ; q=0.6 ", Kazakh},
+		{"q=0 excluded", "ru;q=0, kk;q=0.1", Kazakh},
+		{"q=0.0 excluded", "kk;q=0.0", English},
+		{"unparseable q excluded", "kk;q=high, ru;q=0.5", Russian},
+		{"wildcard", "de, *;q=0.5", English},
+		{"wildcard below supported", "*;q=0.5, kk;q=0.9", Kazakh},
+		{"ties keep the client's order", "kk, ru", Kazakh},
+		{"weighted ties keep the client's order", "ru;q=0.5, kk;q=0.5, en;q=0.5", Russian},
+		{"other parameters", "kk;level=1;q=0.9, ru;q=0.8", Kazakh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.acceptLanguage); got != tt.want {
+				t.Errorf("Negotiate(%q) = %q; want %q", tt.acceptLanguage, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogTranslate(t *testing.T) {
+	c := Catalog{
+		"greeting": {English: "hello %s", Russian: "привет %s"},
+		"plain":    {Russian: "только по-русски"},
+	}
+
+	tests := []struct {
+		locale string
+		code   string
+		args   []interface{}
+		want   string
+	}{
+		{Russian, "greeting", []interface{}{"Аня"}, "привет Аня"},
+		{Kazakh, "greeting", []interface{}{"Ana"}, "hello Ana"},
+		{English, "plain", nil, "plain"},
+		{Russian, "plain", nil, "только по-русски"},
+		{English, "missing", nil, "missing"},
+	}
+
+	for _, tt := range tests {
+		if got := c.Translate(tt.locale, tt.code, tt.args...); got != tt.want {
+			t.Errorf("Translate(%q, %q) = %q; want %q", tt.locale, tt.code, got, tt.want)
+		}
+	}
+}
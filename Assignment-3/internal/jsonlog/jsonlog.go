@@ -1,10 +1,15 @@
 package jsonlog
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,7 +17,9 @@ import (
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,8 +27,12 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -31,65 +42,297 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel converts a level name such as "debug" or "WARN", as given in the
+// -log-level flag, to a Level. "off" disables logging altogether.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	case "off":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("unknown log level %q (must be debug, info, warn, error, fatal or off)", s)
+	}
+}
+
+// Format is the way log entries are written: one JSON object per line, or a line of
+// plain text, which is easier to read when running the API locally.
+type Format int8
+
+const (
+	FormatJSON Format = iota
+	FormatText
+)
+
+// ParseFormat converts "json" or "text", as given in the -log-format flag, to a Format.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON, nil
+	case "text":
+		return FormatText, nil
+	default:
+		return FormatJSON, fmt.Errorf("unknown log format %q (must be json or text)", s)
+	}
+}
+
+// Attr is a property of a log entry. Unlike the map[string]string properties taken by
+// PrintInfo() and friends, its value keeps its type, so numbers are written to the JSON
+// log as numbers.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Duration is written as a string such as "1.5ms".
+func Duration(key string, value time.Duration) Attr {
+	return Attr{Key: key, Value: value.String()}
+}
+
+// Err is written under the "error" key. A nil error is written as null.
+func Err(err error) Attr {
+	if err == nil {
+		return Attr{Key: "error", Value: nil}
+	}
+	return Attr{Key: "error", Value: err.Error()}
+}
+
+// Any is for values of other types, which are written as they would be encoded by
+// encoding/json.
+func Any(key string, value interface{}) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Options configure a Logger. The zero value writes JSON without stack traces.
+type Options struct {
+	Format Format
+	// StackTraces adds the stack trace of the calling goroutine to ERROR and FATAL
+	// entries.
+	StackTraces bool
+}
+
 type Logger struct {
 	out      io.Writer
 	minLevel Level
-	mu       sync.Mutex
+	options  Options
+	attrs    []Attr
+	// The mutex is shared with the child loggers created by With(), because they write
+	// to the same io.Writer.
+	mu *sync.Mutex
 }
 
+// New returns a logger that writes JSON entries at or above minLevel to out, with stack
+// traces for errors.
 func New(out io.Writer, minLevel Level) *Logger {
+	return NewWithOptions(out, minLevel, Options{StackTraces: true})
+}
+
+func NewWithOptions(out io.Writer, minLevel Level, options Options) *Logger {
 	return &Logger{
 		out:      out,
 		minLevel: minLevel,
+		options:  options,
+		mu:       &sync.Mutex{},
 	}
 }
 
+// With returns a child logger that adds the given attributes to every entry, such as
+// the ID of the request being handled.
+func (l *Logger) With(attrs ...Attr) *Logger {
+	child := *l
+	child.attrs = append(append([]Attr(nil), l.attrs...), attrs...)
+	return &child
+}
+
+// Enabled reports whether entries at the given level are written, so that callers can
+// skip building expensive debug attributes.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.minLevel && level < LevelOff
+}
+
+func (l *Logger) Debug(message string, attrs ...Attr) {
+	l.print(LevelDebug, message, attrs)
+}
+
+func (l *Logger) Info(message string, attrs ...Attr) {
+	l.print(LevelInfo, message, attrs)
+}
+
+func (l *Logger) Warn(message string, attrs ...Attr) {
+	l.print(LevelWarn, message, attrs)
+}
+
+func (l *Logger) Error(err error, attrs ...Attr) {
+	l.print(LevelError, err.Error(), attrs)
+}
+
+func (l *Logger) Fatal(err error, attrs ...Attr) {
+	l.print(LevelFatal, err.Error(), attrs)
+	os.Exit(1)
+}
+
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, mapAttrs(properties))
 }
 
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, err.Error(), mapAttrs(properties))
 }
 
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, err.Error(), mapAttrs(properties))
 	os.Exit(1)
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+// mapAttrs converts the properties taken by PrintInfo() and friends to attributes,
+// sorted by key so that text entries come out the same way every time.
+func mapAttrs(properties map[string]string) []Attr {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]Attr, 0, len(properties))
+	for _, key := range keys {
+		attrs = append(attrs, String(key, properties[key]))
+	}
+	return attrs
+}
+
+func (l *Logger) print(level Level, message string, attrs []Attr) (int, error) {
+	if !l.Enabled(level) {
 		return 0, nil
 	}
 
+	if len(l.attrs) > 0 {
+		attrs = append(append([]Attr(nil), l.attrs...), attrs...)
+	}
+
+	now := time.Now().UTC()
+
+	var trace string
+	if l.options.StackTraces && level >= LevelError {
+		trace = string(debug.Stack())
+	}
+
+	var line []byte
+	if l.options.Format == FormatText {
+		line = textLine(now, level, message, attrs, trace)
+	} else {
+		line = jsonLine(now, level, message, attrs, trace)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.out.Write(line)
+}
+
+func jsonLine(now time.Time, level Level, message string, attrs []Attr, trace string) []byte {
+	var properties map[string]interface{}
+	if len(attrs) > 0 {
+		properties = make(map[string]interface{}, len(attrs))
+		for _, attr := range attrs {
+			properties[attr.Key] = attr.Value
+		}
+	}
+
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
+		Time:       now.Format(time.RFC3339),
 		Message:    message,
 		Properties: properties,
+		Trace:      trace,
 	}
 
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack())
-	}
-
-	var line []byte
-
 	line, err := json.Marshal(aux)
 	if err != nil {
 		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return append(line, '\n')
+}
+
+// textLine formats an entry as, for example:
+//
+//	2021-04-19T08:52:56Z INFO request method=GET route=/v1/healthz status=200
+//
+// Values are quoted if they contain spaces or quotes. The stack trace, if any, follows
+// on the next lines.
+func textLine(now time.Time, level Level, message string, attrs []Attr, trace string) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(now.Format(time.RFC3339))
+	buf.WriteByte(' ')
+	buf.WriteString(level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(message)
+
+	for _, attr := range attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(attr.Key)
+		buf.WriteByte('=')
+		buf.WriteString(textValue(attr.Value))
+	}
+
+	buf.WriteByte('\n')
+
+	if trace != "" {
+		buf.WriteString(trace)
+	}
+
+	return buf.Bytes()
+}
 
-	return l.out.Write(append(line, '\n'))
+func textValue(value interface{}) string {
+	var s string
+
+	switch v := value.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		s = v
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
 }
 
 func (l *Logger) Write(message []byte) (n int, err error) {
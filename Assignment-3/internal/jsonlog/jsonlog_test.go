@@ -0,0 +1,286 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeLines decodes each line of JSON log output.
+func decodeLines(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		err := dec.Decode(&entry)
+		if err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestWith(t *testing.T) {
+	var b bytes.Buffer
+	parent := NewWithOptions(&b, LevelInfo, Options{}).With(String("request_id", "abc"))
+
+	// Give the parent's attrs spare capacity, so that children appending to it in
+	// place would overwrite each other's attributes.
+	parent.attrs = append(make([]Attr, 0, 8), parent.attrs...)
+
+	first := parent.With(String("child", "first"))
+	second := parent.With(String("child", "second"))
+
+	first.Info("one")
+	second.Info("two")
+	parent.Info("three", Int("extra", 1))
+	parent.Info("four")
+
+	entries := decodeLines(t, &b)
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries; want 4", len(entries))
+	}
+
+	want := []map[string]interface{}{
+		{"request_id": "abc", "child": "first"},
+		{"request_id": "abc", "child": "second"},
+		{"request_id": "abc", "extra": json.Number("1")},
+		{"request_id": "abc"},
+	}
+
+	for i, entry := range entries {
+		properties, _ := entry["properties"].(map[string]interface{})
+		if len(properties) != len(want[i]) {
+			t.Errorf("entry %d: got properties %v; want %v", i+1, properties, want[i])
+			continue
+		}
+		for key, value := range want[i] {
+			if properties[key] != value {
+				t.Errorf("entry %d: got %s=%v; want %v", i+1, key, properties[key], value)
+			}
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		minLevel Level
+		want     []string
+	}{
+		{LevelDebug, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{LevelInfo, []string{"INFO", "WARN", "ERROR"}},
+		{LevelWarn, []string{"WARN", "ERROR"}},
+		{LevelError, []string{"ERROR"}},
+		{LevelFatal, nil},
+		{LevelOff, nil},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		logger := NewWithOptions(&b, tt.minLevel, Options{})
+
+		logger.Debug("message")
+		logger.Info("message")
+		logger.Warn("message")
+		logger.Error(errors.New("message"))
+		logger.PrintInfo("message", nil)
+		logger.PrintError(errors.New("message"), nil)
+
+		var got []string
+		for _, entry := range decodeLines(t, &b) {
+			got = append(got, entry["level"].(string))
+		}
+
+		// PrintInfo and PrintError write at the same levels as Info and Error.
+		var want []string
+		want = append(want, tt.want...)
+		for _, level := range tt.want {
+			if level == "INFO" || level == "ERROR" {
+				want = append(want, level)
+			}
+		}
+
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("minimum %s: got levels %v; want %v", tt.minLevel, got, want)
+		}
+	}
+
+	// Nothing is ever written at LevelOff, whatever the minimum.
+	logger := NewWithOptions(&bytes.Buffer{}, LevelDebug, Options{})
+	if logger.Enabled(LevelOff) {
+		t.Error("Enabled(LevelOff) = true; want false")
+	}
+	if !logger.Enabled(LevelFatal) {
+		t.Error("Enabled(LevelFatal) = false; want true")
+	}
+	if NewWithOptions(&bytes.Buffer{}, LevelOff, Options{}).Enabled(LevelFatal) {
+		t.Error("LevelOff logger: Enabled(LevelFatal) = true; want false")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{
+		"debug": LevelDebug,
+		"INFO":  LevelInfo,
+		"Warn":  LevelWarn,
+		"error": LevelError,
+		"fatal": LevelFatal,
+		"off":   LevelOff,
+	} {
+		got, err := ParseLevel(s)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(\"verbose\") succeeded; want an error")
+	}
+}
+
+func TestJSONTypedValues(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewWithOptions(&b, LevelInfo, Options{})
+
+	logger.Info("request",
+		Int("status", 200),
+		Int64("bytes", 1<<40),
+		Bool("cached", true),
+		Duration("duration", 1500*time.Microsecond),
+		String("code", "404"),
+		Err(nil),
+		Any("ids", []int{1, 2}),
+	)
+
+	line := b.String()
+	for _, want := range []string{
+		`"status":200`,
+		`"bytes":1099511627776`,
+		`"cached":true`,
+		`"duration":"1.5ms"`,
+		`"code":"404"`,
+		`"error":null`,
+		`"ids":[1,2]`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("got %s; want it to contain %s", line, want)
+		}
+	}
+
+	entries := decodeLines(t, &b)
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries; want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry["level"] != "INFO" || entry["message"] != "request" {
+		t.Errorf("got level %v and message %v", entry["level"], entry["message"])
+	}
+	if _, err := time.Parse(time.RFC3339, entry["time"].(string)); err != nil {
+		t.Errorf("time: %v", err)
+	}
+	if _, found := entry["trace"]; found {
+		t.Error("got a trace for an INFO entry")
+	}
+}
+
+func TestJSONStackTraces(t *testing.T) {
+	var b bytes.Buffer
+	logger := New(&b, LevelInfo)
+
+	logger.Info("no trace")
+	logger.Error(errors.New("with trace"), Err(errors.New("cause")))
+
+	entries := decodeLines(t, &b)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries; want 2", len(entries))
+	}
+	if _, found := entries[0]["trace"]; found {
+		t.Error("INFO entry has a trace")
+	}
+	if trace, _ := entries[1]["trace"].(string); !strings.Contains(trace, "goroutine") {
+		t.Errorf("ERROR entry: got trace %q; want a stack trace", trace)
+	}
+}
+
+func TestTextLine(t *testing.T) {
+	now := time.Date(2021, 4, 19, 8, 52, 56, 0, time.UTC)
+
+	got := string(textLine(now, LevelInfo, "request", []Attr{
+		String("method", "GET"),
+		String("route", "/v1/healthz"),
+		Int("status", 200),
+		String("agent", "curl/8.0 (x86_64)"),
+		String("query", "a=b"),
+		String("quote", `say "hi"`),
+		String("empty", ""),
+		Err(nil),
+		Bool("cached", false),
+		Any("wait", 2*time.Second),
+	}, ""))
+
+	want := `2021-04-19T08:52:56Z INFO request method=GET route=/v1/healthz status=200 agent="curl/8.0 (x86_64)" query="a=b" quote="say \"hi\"" empty="" error=<nil> cached=false wait=2s` + "\n"
+
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = string(textLine(now, LevelError, "boom", nil, "goroutine 1 [running]:\n"))
+	want = "2021-04-19T08:52:56Z ERROR boom\ngoroutine 1 [running]:\n"
+
+	if got != want {
+		t.Errorf("with trace: got %q; want %q", got, want)
+	}
+}
+
+func TestTextValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"plain", "plain"},
+		{"", `""`},
+		{"two words", `"two words"`},
+		{"tab\there", `"tab\there"`},
+		{"new\nline", `"new\nline"`},
+		{`a"b`, `"a\"b"`},
+		{"k=v", `"k=v"`},
+		{nil, "<nil>"},
+		{42, "42"},
+		{3.5, "3.5"},
+		{true, "true"},
+		{time.Minute, "1m0s"},
+		{[]string{"a", "b"}, `"[a b]"`},
+	}
+
+	for _, tt := range tests {
+		if got := textValue(tt.value); got != tt.want {
+			t.Errorf("textValue(%#v) = %s; want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewWithOptions(&b, LevelInfo, Options{Format: FormatText})
+
+	// Anything written to the logger as an io.Writer is logged as an error.
+	_, err := logger.Write([]byte("http: TLS handshake error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.String(), " ERROR http: TLS handshake error\n") {
+		t.Errorf("got %q; want an ERROR entry", b.String())
+	}
+}
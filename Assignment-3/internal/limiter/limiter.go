@@ -0,0 +1,64 @@
+// Package limiter implements the token buckets used by the rate limiter. The buckets
+// live in a Store: MemoryStore keeps them in the process, which is all a single
+// instance of the API needs, and PostgresStore keeps them in the database, so that
+// several instances share one set of limits and the limits survive restarts.
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket. It holds up to Burst tokens, refills at Rate tokens
+// per second, and every request takes a token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// RetryAfter is how long until a token is available again, when the request
+	// wasn't allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+type Store interface {
+	// Allow takes a token from the bucket for key, creating a full bucket if there
+	// isn't one yet.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Prune removes the buckets that haven't been used for the given time. By then
+	// they have refilled, so removing them makes no difference to the limits.
+	Prune(ctx context.Context, idle time.Duration) error
+}
+
+// result works out the Result for a bucket that has the given number of tokens left
+// after the request.
+func (l Limit) result(tokens float64, allowed bool) Result {
+	r := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+	}
+
+	if l.Rate > 0 {
+		if !allowed {
+			r.RetryAfter = seconds((1 - tokens) / l.Rate)
+		}
+		r.Reset = seconds((float64(l.Burst) - tokens) / l.Rate)
+	}
+
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,295 @@
+package limiter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a clock for MemoryStore that only moves when the test says so.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestMemory() (*MemoryStore, *testClock) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemory()
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	store, _ := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	tests := []Result{
+		{Allowed: true, Remaining: 2, Reset: 1 * time.Second},
+		{Allowed: true, Remaining: 1, Reset: 2 * time.Second},
+		{Allowed: true, Remaining: 0, Reset: 3 * time.Second},
+		{Allowed: false, Remaining: 0, RetryAfter: 1 * time.Second, Reset: 3 * time.Second},
+	}
+
+	for i, want := range tests {
+		got, err := store.Allow(context.Background(), "ip:192.0.2.1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("request %d: got %+v; want %+v", i+1, got, want)
+		}
+	}
+
+	// Other keys have buckets of their own.
+	got, err := store.Allow(context.Background(), "ip:192.0.2.2", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Allowed || got.Remaining != 2 {
+		t.Errorf("other key: got %+v; want allowed with 2 remaining", got)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	store, clock := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 3}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := store.Allow(ctx, "key", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Half a token isn't enough, and the client is told to wait for the other half.
+	clock.Advance(500 * time.Millisecond)
+
+	got, err := store.Allow(ctx, "key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{Allowed: false, Remaining: 0, RetryAfter: 500 * time.Millisecond, Reset: 2500 * time.Millisecond}
+	if got != want {
+		t.Errorf("after 0.5s: got %+v; want %+v", got, want)
+	}
+
+	clock.Advance(time.Second)
+
+	got, err = store.Allow(ctx, "key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Result{Allowed: true, Remaining: 0, Reset: 2500 * time.Millisecond}
+	if got != want {
+		t.Errorf("after 1.5s: got %+v; want %+v", got, want)
+	}
+
+	// The bucket never holds more than the burst, however long it is left.
+	clock.Advance(time.Hour)
+
+	got, err = store.Allow(ctx, "key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Result{Allowed: true, Remaining: 2, Reset: time.Second}
+	if got != want {
+		t.Errorf("after an hour: got %+v; want %+v", got, want)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	store, clock := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+
+	_, err := store.Allow(ctx, "old", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(2 * time.Minute)
+
+	_, err = store.Allow(ctx, "new", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Prune(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := store.buckets["old"]; found {
+		t.Error("idle bucket was not pruned")
+	}
+	if _, found := store.buckets["new"]; !found {
+		t.Error("recently used bucket was pruned")
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	store, _ := newTestMemory()
+	testConcurrent(t, store, "key")
+}
+
+// testConcurrent takes tokens from one bucket from many goroutines at once, and checks
+// that exactly the burst is let through.
+func testConcurrent(t *testing.T, store Store, key string) {
+	t.Helper()
+
+	const (
+		burst    = 20
+		requests = 100
+	)
+	// The rate is low enough that the bucket doesn't refill during the test.
+	limit := Limit{Rate: 0.001, Burst: burst}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+		errs    []error
+	)
+
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := store.Allow(context.Background(), key, limit)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if result.Allowed {
+				allowed++
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	if allowed != burst {
+		t.Errorf("got %d requests allowed; want %d", allowed, burst)
+	}
+}
+
+// The PostgresStore tests need a database with the migrations applied, given by the
+// LIMITER_TEST_DB_DSN environment variable, and are skipped without one.
+func newTestPostgres(t *testing.T) (*PostgresStore, string) {
+	t.Helper()
+
+	dsn := os.Getenv("LIMITER_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("LIMITER_TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every test uses keys of its own, which are removed afterwards.
+	prefix := fmt.Sprintf("test:%s:%d:", t.Name(), time.Now().UnixNano())
+
+	t.Cleanup(func() {
+		_, err := db.Exec(`DELETE FROM rate_limit_buckets WHERE key LIKE $1 || '%'`, prefix)
+		if err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	return NewPostgres(db), prefix
+}
+
+func TestPostgresStoreBurst(t *testing.T) {
+	store, prefix := newTestPostgres(t)
+	ctx := context.Background()
+	// The rate is low enough that the bucket doesn't noticeably refill during the
+	// test, so only whole numbers of tokens are compared.
+	limit := Limit{Rate: 0.001, Burst: 3}
+
+	for i, want := range []struct {
+		allowed   bool
+		remaining int
+	}{
+		{true, 2},
+		{true, 1},
+		{true, 0},
+		{false, 0},
+	} {
+		got, err := store.Allow(ctx, prefix+"key", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != want.allowed || got.Remaining != want.remaining {
+			t.Errorf("request %d: got %+v; want allowed %t with %d remaining", i+1, got, want.allowed, want.remaining)
+		}
+		if !got.Allowed && got.RetryAfter <= 0 {
+			t.Errorf("request %d: got RetryAfter %s; want more than 0", i+1, got.RetryAfter)
+		}
+	}
+
+	got, err := store.Allow(ctx, prefix+"other", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Allowed || got.Remaining != 2 {
+		t.Errorf("other key: got %+v; want allowed with 2 remaining", got)
+	}
+}
+
+func TestPostgresStoreRefill(t *testing.T) {
+	store, prefix := newTestPostgres(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Burst: 1}
+
+	first, err := store.Allow(ctx, prefix+"key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Allowed {
+		t.Fatalf("first request: got %+v; want allowed", first)
+	}
+
+	// At 10 tokens a second, the bucket is full again after 100ms.
+	time.Sleep(150 * time.Millisecond)
+
+	got, err := store.Allow(ctx, prefix+"key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Allowed {
+		t.Errorf("after refilling: got %+v; want allowed", got)
+	}
+}
+
+func TestPostgresStoreConcurrent(t *testing.T) {
+	store, prefix := newTestPostgres(t)
+	testConcurrent(t, store, prefix+"key")
+}
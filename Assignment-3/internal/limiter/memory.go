@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps a rate.Limiter for every key. The limits are per process: each
+// instance of the API has its own, and they start afresh when it restarts.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	// now is time.Now, except in tests, which move the clock on themselves.
+	now func() time.Time
+}
+
+type memoryBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewMemory() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket), now: time.Now}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	b, found := s.buckets[key]
+	if !found {
+		b = &memoryBucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		s.buckets[key] = b
+	} else if b.limiter.Limit() != rate.Limit(limit.Rate) || b.limiter.Burst() != limit.Burst {
+		b.limiter.SetLimitAt(now, rate.Limit(limit.Rate))
+		b.limiter.SetBurstAt(now, limit.Burst)
+	}
+	b.lastSeen = now
+
+	allowed := b.limiter.AllowN(now, 1)
+
+	return limit.result(b.limiter.TokensAt(now), allowed), nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, idle time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	for key, b := range s.buckets {
+		if now.Sub(b.lastSeen) > idle {
+			delete(s.buckets, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps the buckets in the rate_limit_buckets table, so that every
+// instance of the API draws on the same buckets. Each request is a single UPSERT,
+// which refills and takes from the bucket atomically, using the database's clock so
+// that the instances' clocks don't need to agree.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (s *PostgresStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	// The refilled bucket is worked out in the sub-select, so that it can be used both
+	// to decide whether the request is allowed and to set the new number of tokens.
+	// RETURNING only sees the new row, which is why whether the request was allowed
+	// is stored in it too.
+	//
+	// The time is read with clock_timestamp() rather than NOW(), which is when the
+	// transaction started. A request that waited on the row lock for another one would
+	// otherwise see an updated_at later than its own NOW(), and take tokens away when
+	// refilling. GREATEST() guards against the same thing if the database's clock is
+	// ever stepped back.
+	query := `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES ($1, GREATEST($3::double precision - 1, 0), $3::double precision >= 1, clock_timestamp())
+		ON CONFLICT (key) DO UPDATE
+		SET (tokens, allowed, updated_at) = (
+			SELECT
+				CASE WHEN refilled >= 1 THEN refilled - 1 ELSE refilled END,
+				refilled >= 1,
+				clock
+			FROM (
+				SELECT clock, LEAST(
+					$3::double precision,
+					b.tokens + GREATEST(EXTRACT(EPOCH FROM clock - b.updated_at)::double precision, 0) * $2::double precision
+				) AS refilled
+				FROM (SELECT clock_timestamp() AS clock) AS c
+			) AS r
+		)
+		RETURNING tokens, allowed`
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+
+	err := s.DB.QueryRowContext(ctx, query, key, limit.Rate, limit.Burst).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return limit.result(tokens, allowed), nil
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, idle time.Duration) error {
+	query := `
+		DELETE FROM rate_limit_buckets
+		WHERE updated_at < NOW() - $1 * interval '1 second'`
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, idle.Seconds())
+	return err
+}
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each email to its own .eml file in a directory instead of sending
+// it. The files can be opened with any email client to check how they look.
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+// NewFile returns a FileMailer that writes to dir, creating the directory if needed.
+func NewFile(dir, sender string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{
+		dir:    dir,
+		sender: sender,
+	}, nil
+}
+
+// Send writes the email to a file named after the time and the template, with a random
+// suffix so that emails sent at the same moment don't overwrite each other.
+func (m *FileMailer) Send(ctx context.Context, recipient, locale, templateFile string, data interface{}) error {
+	msg, err := newMessage(ctx, m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.eml",
+		time.Now().UTC().Format("20060102T150405.000"),
+		filepath.Base(templateFile),
+		hex.EncodeToString(suffix),
+	)
+
+	f, err := os.OpenFile(filepath.Join(m.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Check makes sure that the directory still exists.
+func (m *FileMailer) Check() error {
+	info, err := os.Stat(m.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", m.dir)
+	}
+	return nil
+}
@@ -1,72 +1,31 @@
 package mailer
 
 import (
-	"bytes"
-	"embed"
+	"assignment3.yerniyaz.net/internal/tracing"
+	"context"
 	"github.com/go-mail/mail/v2"
-	"html/template"
-	"time"
 )
 
-// Below we declare a new variable with the type embed.FS (embedded file system) to hold
-// our email templates. This has a comment directive in the format `//go:embed <path>`
-// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
-// ./templates directory in the templateFS embedded file system variable.
-// ↓↓↓
-//
-//go:embed "templates"
-var templateFS embed.FS
-
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
-}
-
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-	// Return a Mailer instance containing the dialer and sender information.
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+// Mailer sends an email rendered from one of the embedded templates. There are three
+// implementations: SMTPMailer delivers through an SMTP server, FileMailer writes .eml
+// files to a directory and LogMailer writes the emails to the application log. Only
+// the SMTP one ever leaves the machine, so the other two are safe to use in
+// development and tests.
+type Mailer interface {
+	// Send takes the recipient email address and locale, the name of the file
+	// containing the templates, and any dynamic data for the templates. If ctx
+	// carries a span, its trace context is added to the email's Traceparent header.
+	Send(ctx context.Context, recipient, locale, templateFile string, data interface{}) error
+	// Check reports whether emails can currently be sent, for the readiness check.
+	Check() error
 }
 
-// Define a Send() method on the Mailer type. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an interface{} parameter.
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// newMessage renders the template file for the locale and assembles it into a message
+// from the sender to the recipient.
+func newMessage(ctx context.Context, sender, recipient, locale, templateFile string, data interface{}) (*mail.Message, error) {
+	email, err := Render(Templates(), templatePath(locale, templateFile), data)
 	if err != nil {
-		return err
-	}
-	// Execute the named template "subject", passing in the dynamic data and storing the
-	// result in a bytes.Buffer variable.
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return err
-	}
-	// Follow the same pattern to execute the "plainBody" template and store the result
-	// in the plainBody variable.
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	if err != nil {
-		return err
-	}
-	// And likewise with the "htmlBody" template.
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
-	if err != nil {
-		return err
+		return nil, err
 	}
 	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
 	// Then we use the SetHeader() method to set the email recipient, sender and subject
@@ -75,17 +34,14 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	// always be called *after* SetBody().
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error.
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
-		return err
+	msg.SetHeader("From", sender)
+	msg.SetHeader("Subject", email.Subject)
+	msg.SetBody("text/plain", email.PlainBody)
+	msg.AddAlternative("text/html", email.HTMLBody)
+
+	if traceparent := tracing.SpanFromContext(ctx).SpanContext().Traceparent(); traceparent != "" {
+		msg.SetHeader("Traceparent", traceparent)
 	}
-	return nil
+
+	return msg, nil
 }
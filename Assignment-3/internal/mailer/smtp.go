@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"github.com/go-mail/mail/v2"
+	"time"
+)
+
+// Define a SMTPMailer struct which contains a mail.Dialer instance (used to connect to
+// a SMTP server) and the sender information for your emails (the name and address you
+// want the email to be from, such as "Alice Smith <alice@example.com>").
+type SMTPMailer struct {
+	dialer *mail.Dialer
+	sender string
+}
+
+func NewSMTP(host string, port int, username, password, sender string) *SMTPMailer {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
+	// also configure this to use a 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+	// Return a SMTPMailer instance containing the dialer and sender information.
+	return &SMTPMailer{
+		dialer: dialer,
+		sender: sender,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, recipient, locale, templateFile string, data interface{}) error {
+	msg, err := newMessage(ctx, m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+	// Call the DialAndSend() method on the dialer, passing in the message to send. This
+	// opens a connection to the SMTP server, sends the message, then closes the
+	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
+	// error.
+	return m.dialer.DialAndSend(msg)
+}
+
+// Check connects to the SMTP server and authenticates, without sending anything.
+func (m *SMTPMailer) Check() error {
+	conn, err := m.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"assignment3.yerniyaz.net/internal/i18n"
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+// ↓↓↓
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Blocks lists the templates that every template file must define.
+var Blocks = []string{"subject", "plainBody", "htmlBody"}
+
+// Email is an email rendered from a template file.
+type Email struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Templates returns the embedded template files. Each locale has its own directory,
+// so the paths in it look like "ru/user_welcome.tmpl".
+func Templates() fs.FS {
+	fsys, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// templatePath returns the path of a template file for a locale. A template that
+// hasn't been translated yet is taken from the default locale's directory instead.
+func templatePath(locale, templateFile string) string {
+	p := locale + "/" + templateFile
+
+	_, err := fs.Stat(Templates(), p)
+	if err != nil {
+		p = i18n.Default + "/" + templateFile
+	}
+
+	return p
+}
+
+// ListTemplates returns the path of every .tmpl file in fsys, sorted by path.
+func ListTemplates(fsys fs.FS) ([]string, error) {
+	var paths []string
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && path.Ext(p) == ".tmpl" {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+func parse(fsys fs.FS, p string) (*template.Template, error) {
+	// Use the ParseFS() method to parse the required template file from the file
+	// system.
+	return template.New("email").ParseFS(fsys, p)
+}
+
+// CheckTemplate returns an error if the template file at path p in fsys can't be
+// parsed, or doesn't define all of the Blocks.
+func CheckTemplate(fsys fs.FS, p string) error {
+	tmpl, err := parse(fsys, p)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, block := range Blocks {
+		if tmpl.Lookup(block) == nil {
+			missing = append(missing, block)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: missing %s", p, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Render executes the "subject", "plainBody" and "htmlBody" templates in the template
+// file at path p in fsys, passing in the dynamic data.
+func Render(fsys fs.FS, p string, data interface{}) (*Email, error) {
+	tmpl, err := parse(fsys, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the named template "subject", passing in the dynamic data and storing the
+	// result in a bytes.Buffer variable.
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	// Follow the same pattern to execute the "plainBody" template and store the result
+	// in the plainBody variable.
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+	// And likewise with the "htmlBody" template.
+	htmlBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Email{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
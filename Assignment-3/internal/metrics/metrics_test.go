@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("http_requests_total", "Total requests.\nCounted once each, with a \\ in the help.", "method", "status")
+	requests.Inc("GET", "200")
+	requests.Inc("POST", "500")
+	requests.Inc("GET", "200")
+	requests.Add(0.5, "a\"b\\c\nd", "200")
+
+	r.NewCounter("jobs_total", "Jobs run.")
+
+	// The buckets are given out of order, and sorted.
+	duration := r.NewHistogram("request_duration_seconds", "Request durations.", []float64{1, 0.1, 0.5}, "route")
+	duration.Observe(0.0625, "/a")
+	duration.Observe(0.25, "/a")
+	duration.Observe(2, "/a")
+	// An observation on a bucket's upper bound is counted in that bucket.
+	duration.Observe(0.1, "/b")
+
+	r.NewHistogram("unused_seconds", "Never observed.", DefaultBuckets)
+
+	r.NewGaugeFunc("goroutines", "Goroutines.", func() float64 { return 7 })
+
+	var b bytes.Buffer
+	err := r.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP http_requests_total Total requests.\nCounted once each, with a \\ in the help.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",status="200"} 2
+http_requests_total{method="POST",status="500"} 1
+http_requests_total{method="a\"b\\c\nd",status="200"} 0.5
+# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total 0
+# HELP request_duration_seconds Request durations.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/a",le="0.1"} 1
+request_duration_seconds_bucket{route="/a",le="0.5"} 2
+request_duration_seconds_bucket{route="/a",le="1"} 2
+request_duration_seconds_bucket{route="/a",le="+Inf"} 3
+request_duration_seconds_sum{route="/a"} 2.3125
+request_duration_seconds_count{route="/a"} 3
+request_duration_seconds_bucket{route="/b",le="0.1"} 1
+request_duration_seconds_bucket{route="/b",le="0.5"} 1
+request_duration_seconds_bucket{route="/b",le="1"} 1
+request_duration_seconds_bucket{route="/b",le="+Inf"} 1
+request_duration_seconds_sum{route="/b"} 0.1
+request_duration_seconds_count{route="/b"} 1
+# HELP unused_seconds Never observed.
+# TYPE unused_seconds histogram
+# HELP goroutines Goroutines.
+# TYPE goroutines gauge
+goroutines 7
+`
+
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.5})
+	h.Observe(0.25)
+	h.Observe(0.75)
+
+	var b bytes.Buffer
+	err := r.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 1
+latency_seconds_count 2
+`
+
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("jobs_total", "Jobs run.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/debug/metrics", nil))
+
+	if got, want := rr.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("got Content-Type %q; want %q", got, want)
+	}
+	if !bytes.Contains(rr.Body.Bytes(), []byte("\njobs_total 1\n")) {
+		t.Errorf("got body %q; want it to contain the counter", rr.Body.String())
+	}
+}
@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test secret from appendix B of RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The RFC gives eight digit codes; these are their last six digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.code {
+			t.Errorf("T=%d: got %s; want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	// Codes for the current period and one either side are accepted, and the step of
+	// the one that matched is returned.
+	for _, step := range []int64{current - Skew, current, current + Skew} {
+		got, ok := Validate(rfcSecret, Code(rfcSecret, step), now)
+		if !ok {
+			t.Errorf("step %+d: code refused; want it accepted", step-current)
+			continue
+		}
+		if got != step {
+			t.Errorf("step %+d: got step %d; want %d", step-current, got, step)
+		}
+	}
+
+	for _, step := range []int64{current - Skew - 1, current + Skew + 1} {
+		if _, ok := Validate(rfcSecret, Code(rfcSecret, step), now); ok {
+			t.Errorf("step %+d: code accepted; want it refused", step-current)
+		}
+	}
+
+	code := Code(rfcSecret, current)
+
+	// Spaces, as in "005 924", are ignored.
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("code with a space refused; want it accepted")
+	}
+
+	for _, bad := range []string{"", code[:Digits-1], code + "0", "0" + code, strings.Repeat("x", Digits)} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Validate(%q) accepted; want it refused", bad)
+		}
+	}
+
+	if _, ok := Validate([]byte("another secret"), code, now); ok {
+		t.Error("code for another secret accepted; want it refused")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Remote Cars", "alice@example.com", rfcSecret)
+	want := "otpauth://totp/Remote%20Cars:alice@example.com?algorithm=SHA1&digits=6&issuer=Remote+Cars&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
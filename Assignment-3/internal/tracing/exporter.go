@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Exporter sends a batch of spans, already encoded as an OTLP/JSON export request,
+// somewhere it can be looked at. There are two implementations: FileExporter appends
+// the batches to a file, and HTTPExporter posts them to a collector.
+type Exporter interface {
+	Export(ctx context.Context, body []byte) error
+	Close() error
+}
+
+// FileExporter writes one export request per line, the same format the OpenTelemetry
+// Collector's file exporter uses, so the file can be replayed into a collector or
+// read with jq.
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFile(path string) (*FileExporter, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileExporter{file: file}, nil
+}
+
+func (e *FileExporter) Export(ctx context.Context, body []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.file.Write(append(body, '\n'))
+	return err
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.file.Close()
+}
+
+// HTTPExporter posts batches to the OTLP/HTTP traces endpoint of a collector, such as
+// http://localhost:4318/v1/traces.
+type HTTPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func NewHTTP(endpoint string) *HTTPExporter {
+	return &HTTPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *HTTPExporter) Export(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("tracing: collector responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (e *HTTPExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// The types below mirror the parts of the OTLP ExportTraceServiceRequest message that
+// we use, in the protocol's JSON encoding: IDs are hex strings, and 64-bit integers
+// are decimal strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// Status codes are 0 for unset and 2 for error; we never set 1 (ok).
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+const scopeName = "assignment3.yerniyaz.net/internal/tracing"
+
+// encode returns a batch of spans as the JSON body of an OTLP export request.
+func encode(options Options, batch []*Span) ([]byte, error) {
+	resource := []Attr{String("service.name", options.ServiceName)}
+	if options.ServiceVersion != "" {
+		resource = append(resource, String("service.version", options.ServiceVersion))
+	}
+
+	spans := make([]otlpSpan, 0, len(batch))
+
+	for _, s := range batch {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttrs(s.attrs),
+		}
+		if s.failed {
+			span.Status = otlpStatus{Code: 2, Message: s.statusMessage}
+		}
+		s.mu.Unlock()
+
+		if s.parentID.IsValid() {
+			span.ParentSpanID = s.parentID.String()
+		}
+
+		spans = append(spans, span)
+	}
+
+	return json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: encodeAttrs(resource)},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: spans,
+			}},
+		}},
+	})
+}
+
+func encodeAttrs(attrs []Attr) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+
+	for _, attr := range attrs {
+		var value otlpAnyValue
+
+		switch v := attr.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case bool:
+			value.BoolValue = &v
+		default:
+			continue
+		}
+
+		kvs = append(kvs, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+
+	return kvs
+}
@@ -0,0 +1,434 @@
+// Package tracing records spans for the requests the API handles, the database calls
+// made while handling them, and the webhooks and emails sent afterwards, and exports
+// them in the OpenTelemetry protocol's JSON encoding (OTLP/JSON). Trace context is
+// passed between services in the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanID [8]byte
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span, which may belong to another service, and whether its
+// trace is being recorded.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns the span context as a W3C traceparent header value, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", or "" if it is invalid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value. It reports false if the
+// value is missing or malformed, in which case the caller should start a new trace.
+// Versions after 00 are accepted as long as they start with the fields defined by
+// version 00, as the specification requires.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return SpanContext{}, false
+	}
+
+	version, ok := decodeHex(s[0:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+
+	traceID, ok := decodeHex(s[3:35])
+	if !ok {
+		return SpanContext{}, false
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanID, ok := decodeHex(s[36:52])
+	if !ok {
+		return SpanContext{}, false
+	}
+	copy(sc.SpanID[:], spanID)
+
+	flags, ok := decodeHex(s[53:55])
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+
+	return sc, true
+}
+
+// decodeHex decodes lowercase hex only, because the traceparent header doesn't allow
+// uppercase.
+func decodeHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// SpanKind says what a span represents, using the values from the OTLP protocol.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attr is an attribute of a span.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: int64(value)}
+}
+
+func Int64(key string, value int64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Span is a timed operation within a trace. All of its methods can be called on a nil
+// *Span, which is what Start() returns when tracing is disabled, so callers never need
+// to check.
+type Span struct {
+	tracer   *Tracer
+	sc       SpanContext
+	parentID SpanID
+	kind     SpanKind
+	start    time.Time
+
+	mu            sync.Mutex
+	name          string
+	end           time.Time
+	attrs         []Attr
+	failed        bool
+	statusMessage string
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames the span, for example once the route a request matched is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.statusMessage = err.Error()
+}
+
+// End records the span's end time and queues it to be exported, if its trace is
+// sampled. Calling End() more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type contextKey string
+
+const spanContextKey = contextKey("span")
+
+// ContextWithSpan returns a copy of ctx that carries the span, so that spans started
+// from it become its children.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil if there isn't one.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+// Start starts a child of the span in ctx, using the same tracer. If ctx has no span
+// the operation is not part of a trace, and Start() returns ctx and a nil span.
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.StartRemote(ctx, parent.sc, name, kind, attrs...)
+}
+
+// Options configure a Tracer. Zero values are replaced with the defaults.
+type Options struct {
+	ServiceName    string
+	ServiceVersion string
+	// Spans are exported in batches of up to BatchSize, at least every FlushInterval.
+	BatchSize     int
+	FlushInterval time.Duration
+	// ErrorLog is called when a batch can't be exported, or spans had to be dropped
+	// because the exporter couldn't keep up.
+	ErrorLog func(error)
+}
+
+const (
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	queueSize            = 4096
+)
+
+// Tracer starts spans and exports the finished ones in the background. A nil *Tracer
+// is valid and starts no spans, which is how tracing is turned off.
+type Tracer struct {
+	exporter Exporter
+	options  Options
+	queue    chan *Span
+	dropped  atomic.Int64
+	quit     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func New(exporter Exporter, options Options) *Tracer {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaultFlushInterval
+	}
+	if options.ErrorLog == nil {
+		options.ErrorLog = func(error) {}
+	}
+
+	t := &Tracer{
+		exporter: exporter,
+		options:  options,
+		queue:    make(chan *Span, queueSize),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Start starts a span that is a child of the span in ctx, or the root of a new trace if
+// ctx doesn't have one. It returns a copy of ctx carrying the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	return t.StartRemote(ctx, SpanFromContext(ctx).SpanContext(), name, kind, attrs...)
+}
+
+// StartRemote starts a span that is a child of the given span context, which usually
+// comes from a traceparent header, or the root of a new trace if the span context is
+// invalid. A span whose parent isn't sampled isn't either, but its span context is
+// still passed on.
+func (t *Tracer) StartRemote(ctx context.Context, parent SpanContext, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+
+	if parent.IsValid() {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.parentID = parent.SpanID
+	} else {
+		randomID(span.sc.TraceID[:])
+		span.sc.Sampled = true
+	}
+	randomID(span.sc.SpanID[:])
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func randomID(b []byte) {
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// enqueue hands a finished span to the export goroutine. Spans are dropped, rather than
+// holding up the request, if the queue is full.
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(t.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.options.BatchSize)
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.options.BatchSize {
+				batch = t.flush(batch)
+			}
+		case <-ticker.C:
+			batch = t.flush(batch)
+		case <-t.quit:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+					if len(batch) >= t.options.BatchSize {
+						batch = t.flush(batch)
+					}
+				default:
+					t.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush exports a batch of spans and returns the emptied batch for reuse.
+func (t *Tracer) flush(batch []*Span) []*Span {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		t.options.ErrorLog(fmt.Errorf("tracing: span queue full, dropped %d spans", dropped))
+	}
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	body, err := encode(t.options, batch)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = t.exporter.Export(ctx, body)
+		cancel()
+	}
+	if err != nil {
+		t.options.ErrorLog(err)
+	}
+
+	for i := range batch {
+		batch[i] = nil
+	}
+	return batch[:0]
+}
+
+// Shutdown exports the spans that have already ended and closes the exporter. Spans
+// that end afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() { close(t.quit) })
+
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.exporter.Close()
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-09", true, true},
+		{"empty", "", false, false},
+		{"uppercase trace ID", "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", false, false},
+		{"uppercase span ID", "00-" + traceID + "-" + strings.ToUpper(spanID) + "-01", false, false},
+		{"uppercase version", "0A-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase flags", "00-" + traceID + "-" + spanID + "-0A", false, false},
+		{"all-zero trace ID", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false, false},
+		{"all-zero span ID", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"version not hex", "0g-" + traceID + "-" + spanID + "-01", false, false},
+		{"version 00 too long", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"version 00 one byte too long", "00-" + traceID + "-" + spanID + "-010", false, false},
+		{"too short", "00-" + traceID + "-" + spanID + "-1", false, false},
+		{"future version", "01-" + traceID + "-" + spanID + "-01", true, true},
+		{"future version with more fields", "cc-" + traceID + "-" + spanID + "-01-what-the-future-holds", true, true},
+		{"future version without a dash after the flags", "cc-" + traceID + "-" + spanID + "-01x", false, false},
+		{"wrong separator", "00_" + traceID + "-" + spanID + "-01", false, false},
+		{"short trace ID", "00-" + traceID[1:] + "-" + spanID + "-01-", false, false},
+		{"non-hex span ID", "00-" + traceID + "-" + spanID[:15] + "z-01", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			if ok != tt.valid {
+				t.Fatalf("got valid %t; want %t", ok, tt.valid)
+			}
+			if !ok {
+				if sc != (SpanContext{}) {
+					t.Errorf("got %+v; want the zero SpanContext", sc)
+				}
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID {
+				t.Errorf("got trace ID %s and span ID %s; want %s and %s", sc.TraceID, sc.SpanID, traceID, spanID)
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("got sampled %t; want %t", sc.Sampled, tt.sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00",
+	} {
+		sc, ok := ParseTraceparent(value)
+		if !ok {
+			t.Fatalf("ParseTraceparent(%q) failed", value)
+		}
+		if got := sc.Traceparent(); got != value {
+			t.Errorf("got %q; want %q", got, value)
+		}
+	}
+
+	// Only the sampled flag is kept, and a future version is written back as 00.
+	sc, ok := ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-ff-more")
+	if !ok {
+		t.Fatal("ParseTraceparent failed for a future version")
+	}
+	if got, want := sc.Traceparent(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	if got := (SpanContext{}).Traceparent(); got != "" {
+		t.Errorf("invalid span context: got %q; want \"\"", got)
+	}
+}
@@ -0,0 +1,306 @@
+package validator
+
+import "assignment3.yerniyaz.net/internal/i18n"
+
+// The validation error codes. They are part of the API, returned to clients alongside
+// the translated messages, so existing codes must never be renamed. The comment after
+// each one lists the arguments that Check() and AddError() expect for it.
+const (
+	Required           = "required"
+	InvalidEmail       = "invalid_email"
+	MinBytes           = "min_bytes"     // length
+	MaxBytes           = "max_bytes"     // length
+	ExactBytes         = "exact_bytes"   // length
+	Between            = "between"       // min, max
+	FieldBetween       = "field_between" // field name, min, max
+	GreaterThanZero    = "greater_than_zero"
+	PositiveInteger    = "positive_integer"
+	Positive           = "positive"
+	NotNegative        = "not_negative"
+	Max                = "max"    // max
+	OneOf              = "one_of" // comma separated list of values
+	NotInFuture        = "not_in_future"
+	MaxAgeDays         = "max_age_days" // days
+	Duplicates         = "duplicates"
+	MaxEntries         = "max_entries"     // count
+	MaxValueBytes      = "max_value_bytes" // length
+	NotEmpty           = "not_empty"
+	OnlyContain        = "only_contain" // comma separated list of values
+	Slug               = "slug"
+	InvalidSort        = "invalid_sort"
+	Integer            = "integer"
+	Number             = "number"
+	LatLng             = "lat_lng"
+	Timestamp          = "timestamp"
+	Duration           = "duration"
+	MinDuration        = "min_duration" // duration
+	MaxDuration        = "max_duration" // duration
+	WholeSeconds       = "whole_seconds"
+	MaxBuckets         = "max_buckets"   // count
+	Before             = "before"        // other field
+	RequiredWith       = "required_with" // other field
+	OnlyWith           = "only_with"     // other field
+	OnlyFor            = "only_for"      // value
+	SortRequires       = "sort_requires" // sort column, other field
+	RequiredOnFailure  = "required_on_failure"
+	ForbiddenOnSuccess = "forbidden_on_success"
+	AbsoluteURL        = "absolute_url"
+	HTTPScheme         = "http_scheme"
+	DeviceKey          = "device_key"
+	ExistingCategories = "existing_categories"
+	ExistingRemoteCar  = "existing_remote_car"
+	DuplicateEmail     = "duplicate_email"
+	DuplicateCategory  = "duplicate_category"
+	DuplicateHardware  = "duplicate_hardware_id"
+	DuplicateReview    = "duplicate_review"
+	InvalidToken       = "invalid_token"
+	InvalidTOTPCode    = "invalid_totp_code"
+)
+
+var messages = i18n.Catalog{
+	Required: {
+		i18n.English: "must be provided",
+		i18n.Russian: "обязательное поле",
+		i18n.Kazakh:  "міндетті өріс",
+	},
+	InvalidEmail: {
+		i18n.English: "must be a valid email address",
+		i18n.Russian: "должно быть корректным адресом электронной почты",
+		i18n.Kazakh:  "жарамды электрондық пошта мекенжайы болуы керек",
+	},
+	MinBytes: {
+		i18n.English: "must be at least %d bytes long",
+		i18n.Russian: "должно быть не короче %d байт",
+		i18n.Kazakh:  "кемінде %d байт болуы керек",
+	},
+	MaxBytes: {
+		i18n.English: "must not be more than %d bytes long",
+		i18n.Russian: "должно быть не длиннее %d байт",
+		i18n.Kazakh:  "%d байттан аспауы керек",
+	},
+	ExactBytes: {
+		i18n.English: "must be %d bytes long",
+		i18n.Russian: "должно быть длиной %d байт",
+		i18n.Kazakh:  "ұзындығы %d байт болуы керек",
+	},
+	Between: {
+		i18n.English: "must be between %v and %v",
+		i18n.Russian: "должно быть от %v до %v",
+		i18n.Kazakh:  "%v мен %v аралығында болуы керек",
+	},
+	FieldBetween: {
+		i18n.English: "%s must be between %v and %v",
+		i18n.Russian: "%s должно быть от %v до %v",
+		i18n.Kazakh:  "%s %v мен %v аралығында болуы керек",
+	},
+	GreaterThanZero: {
+		i18n.English: "must be greater than zero",
+		i18n.Russian: "должно быть больше нуля",
+		i18n.Kazakh:  "нөлден үлкен болуы керек",
+	},
+	PositiveInteger: {
+		i18n.English: "must be a positive integer",
+		i18n.Russian: "должно быть положительным целым числом",
+		i18n.Kazakh:  "оң бүтін сан болуы керек",
+	},
+	Positive: {
+		i18n.English: "must be positive",
+		i18n.Russian: "должно быть положительным",
+		i18n.Kazakh:  "оң болуы керек",
+	},
+	NotNegative: {
+		i18n.English: "must not be negative",
+		i18n.Russian: "не может быть отрицательным",
+		i18n.Kazakh:  "теріс болмауы керек",
+	},
+	Max: {
+		i18n.English: "must be a maximum of %v",
+		i18n.Russian: "должно быть не больше %v",
+		i18n.Kazakh:  "%v мәнінен аспауы керек",
+	},
+	OneOf: {
+		i18n.English: "must be one of %s",
+		i18n.Russian: "должно быть одним из значений: %s",
+		i18n.Kazakh:  "мына мәндердің бірі болуы керек: %s",
+	},
+	NotInFuture: {
+		i18n.English: "must not be in the future",
+		i18n.Russian: "не может быть в будущем",
+		i18n.Kazakh:  "болашақта болмауы керек",
+	},
+	MaxAgeDays: {
+		i18n.English: "must not be more than %d days ago",
+		i18n.Russian: "не может быть раньше чем %d дней назад",
+		i18n.Kazakh:  "%d күннен бұрын болмауы керек",
+	},
+	Duplicates: {
+		i18n.English: "must not contain duplicate values",
+		i18n.Russian: "не должно содержать повторяющихся значений",
+		i18n.Kazakh:  "қайталанатын мәндер болмауы керек",
+	},
+	MaxEntries: {
+		i18n.English: "must not contain more than %d entries",
+		i18n.Russian: "должно содержать не более %d элементов",
+		i18n.Kazakh:  "%d элементтен көп болмауы керек",
+	},
+	MaxValueBytes: {
+		i18n.English: "must not contain values longer than %d bytes",
+		i18n.Russian: "не должно содержать значений длиннее %d байт",
+		i18n.Kazakh:  "%d байттан ұзын мәндер болмауы керек",
+	},
+	NotEmpty: {
+		i18n.English: "must not be empty",
+		i18n.Russian: "не должно быть пустым",
+		i18n.Kazakh:  "бос болмауы керек",
+	},
+	OnlyContain: {
+		i18n.English: "must only contain values from %s",
+		i18n.Russian: "должно содержать только значения из списка: %s",
+		i18n.Kazakh:  "тек мына мәндерден тұруы керек: %s",
+	},
+	Slug: {
+		i18n.English: "must contain only lowercase letters, digits and hyphens",
+		i18n.Russian: "должно содержать только строчные буквы, цифры и дефисы",
+		i18n.Kazakh:  "тек кіші әріптерден, сандардан және дефистерден тұруы керек",
+	},
+	InvalidSort: {
+		i18n.English: "invalid sort value",
+		i18n.Russian: "недопустимое значение сортировки",
+		i18n.Kazakh:  "сұрыптау мәні жарамсыз",
+	},
+	Integer: {
+		i18n.English: "must be an integer value",
+		i18n.Russian: "должно быть целым числом",
+		i18n.Kazakh:  "бүтін сан болуы керек",
+	},
+	Number: {
+		i18n.English: "must be a number",
+		i18n.Russian: "должно быть числом",
+		i18n.Kazakh:  "сан болуы керек",
+	},
+	LatLng: {
+		i18n.English: "must be in the format latitude,longitude",
+		i18n.Russian: "должно быть в формате широта,долгота",
+		i18n.Kazakh:  "ендік,бойлық пішімінде болуы керек",
+	},
+	Timestamp: {
+		i18n.English: "must be an RFC 3339 timestamp",
+		i18n.Russian: "должно быть временем в формате RFC 3339",
+		i18n.Kazakh:  "RFC 3339 пішіміндегі уақыт болуы керек",
+	},
+	Duration: {
+		i18n.English: "must be a duration such as 30s, 5m or 1h",
+		i18n.Russian: "должно быть длительностью, например 30s, 5m или 1h",
+		i18n.Kazakh:  "ұзақтық болуы керек, мысалы 30s, 5m немесе 1h",
+	},
+	MinDuration: {
+		i18n.English: "must be at least %s",
+		i18n.Russian: "должно быть не меньше %s",
+		i18n.Kazakh:  "кемінде %s болуы керек",
+	},
+	MaxDuration: {
+		i18n.English: "must not be more than %s",
+		i18n.Russian: "должно быть не больше %s",
+		i18n.Kazakh:  "%s мәнінен аспауы керек",
+	},
+	WholeSeconds: {
+		i18n.English: "must be a whole number of seconds",
+		i18n.Russian: "должно быть целым числом секунд",
+		i18n.Kazakh:  "бүтін секунд саны болуы керек",
+	},
+	MaxBuckets: {
+		i18n.English: "must not produce more than %d buckets",
+		i18n.Russian: "не должно давать больше %d интервалов",
+		i18n.Kazakh:  "%d аралықтан көп бермеуі керек",
+	},
+	Before: {
+		i18n.English: "must be before %s",
+		i18n.Russian: "должно быть раньше %s",
+		i18n.Kazakh:  "%s мәнінен бұрын болуы керек",
+	},
+	RequiredWith: {
+		i18n.English: "must be provided together with %s",
+		i18n.Russian: "должно быть указано вместе с %s",
+		i18n.Kazakh:  "%s өрісімен бірге берілуі керек",
+	},
+	OnlyWith: {
+		i18n.English: "must only be provided together with %s",
+		i18n.Russian: "можно указывать только вместе с %s",
+		i18n.Kazakh:  "тек %s өрісімен бірге берілуі мүмкін",
+	},
+	OnlyFor: {
+		i18n.English: "must only be provided for %s",
+		i18n.Russian: "можно указывать только для %s",
+		i18n.Kazakh:  "тек %s үшін берілуі мүмкін",
+	},
+	SortRequires: {
+		i18n.English: "%s sorting requires %s",
+		i18n.Russian: "для сортировки по %s требуется %s",
+		i18n.Kazakh:  "%s бойынша сұрыптау үшін %s қажет",
+	},
+	RequiredOnFailure: {
+		i18n.English: "must be provided when success is false",
+		i18n.Russian: "должно быть указано, если success равно false",
+		i18n.Kazakh:  "success мәні false болғанда берілуі керек",
+	},
+	ForbiddenOnSuccess: {
+		i18n.English: "must not be provided when success is true",
+		i18n.Russian: "не должно указываться, если success равно true",
+		i18n.Kazakh:  "success мәні true болғанда берілмеуі керек",
+	},
+	AbsoluteURL: {
+		i18n.English: "must be an absolute URL",
+		i18n.Russian: "должно быть абсолютным URL",
+		i18n.Kazakh:  "абсолютті URL болуы керек",
+	},
+	HTTPScheme: {
+		i18n.English: "must use http or https",
+		i18n.Russian: "должно использовать http или https",
+		i18n.Kazakh:  "http немесе https қолдануы керек",
+	},
+	DeviceKey: {
+		i18n.English: "must be a device key",
+		i18n.Russian: "должно быть ключом устройства",
+		i18n.Kazakh:  "құрылғы кілті болуы керек",
+	},
+	ExistingCategories: {
+		i18n.English: "must only contain existing categories",
+		i18n.Russian: "должно содержать только существующие категории",
+		i18n.Kazakh:  "тек бар санаттардан тұруы керек",
+	},
+	ExistingRemoteCar: {
+		i18n.English: "must be the id of an existing remote car",
+		i18n.Russian: "должно быть идентификатором существующей машины",
+		i18n.Kazakh:  "бар машинаның идентификаторы болуы керек",
+	},
+	DuplicateEmail: {
+		i18n.English: "a user with this email address already exists",
+		i18n.Russian: "пользователь с таким адресом электронной почты уже существует",
+		i18n.Kazakh:  "бұл электрондық пошта мекенжайымен пайдаланушы бұрыннан тіркелген",
+	},
+	DuplicateCategory: {
+		i18n.English: "a category with this name already exists",
+		i18n.Russian: "категория с таким названием уже существует",
+		i18n.Kazakh:  "мұндай атауы бар санат бұрыннан бар",
+	},
+	DuplicateHardware: {
+		i18n.English: "a device with this hardware id already exists",
+		i18n.Russian: "устройство с таким аппаратным идентификатором уже существует",
+		i18n.Kazakh:  "мұндай аппараттық идентификаторы бар құрылғы бұрыннан бар",
+	},
+	DuplicateReview: {
+		i18n.English: "you have already reviewed this remote car",
+		i18n.Russian: "вы уже оставили отзыв об этой машине",
+		i18n.Kazakh:  "сіз бұл машинаға пікір қалдырып қойғансыз",
+	},
+	InvalidToken: {
+		i18n.English: "invalid or expired activation token",
+		i18n.Russian: "недействительный или просроченный токен активации",
+		i18n.Kazakh:  "белсендіру токені жарамсыз немесе мерзімі өткен",
+	},
+	InvalidTOTPCode: {
+		i18n.English: "invalid or already used code",
+		i18n.Russian: "неверный или уже использованный код",
+		i18n.Kazakh:  "код қате немесе бұрын қолданылған",
+	},
+}
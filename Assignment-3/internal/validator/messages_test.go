@@ -0,0 +1,75 @@
+package validator
+
+import (
+	"assignment3.yerniyaz.net/internal/i18n"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var verbRX = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]+)?[a-zA-Z%]`)
+
+// TestMessagesTranslated checks that every message is translated into every locale,
+// and that the translations take the same arguments as the English message.
+func TestMessagesTranslated(t *testing.T) {
+	for code, translations := range messages {
+		english := verbRX.FindAllString(translations[i18n.English], -1)
+
+		for _, locale := range i18n.Locales {
+			format, ok := translations[locale]
+			if !ok || format == "" {
+				t.Errorf("%s: no %s message", code, locale)
+				continue
+			}
+
+			if verbs := verbRX.FindAllString(format, -1); !reflect.DeepEqual(verbs, english) {
+				t.Errorf("%s: %s message has verbs %q; English has %q", code, locale, verbs, english)
+			}
+		}
+	}
+}
+
+// TestCodesHaveMessages checks that every error code declared in messages.go has a
+// message, so that a new code can't be added without one.
+func TestCodesHaveMessages(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "messages.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes int
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			for _, value := range spec.(*ast.ValueSpec).Values {
+				lit, ok := value.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+
+				code, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				codes++
+
+				if _, ok := messages[code]; !ok {
+					t.Errorf("code %q has no message", code)
+				}
+			}
+		}
+	}
+
+	if codes != len(messages) {
+		t.Errorf("found %d codes but %d messages", codes, len(messages))
+	}
+}
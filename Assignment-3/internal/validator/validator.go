@@ -6,13 +6,26 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// Error is a single validation failure. The Code is stable, so clients can rely on it
+// instead of the message text, and the Args are the values that get substituted into
+// the message when it is translated (such as the maximum length for MaxBytes).
+type Error struct {
+	Code string
+	Args []interface{}
+}
+
+// Message returns the error message translated into the given locale.
+func (e Error) Message(locale string) string {
+	return messages.Translate(locale, e.Code, e.Args...)
+}
+
 type Validator struct {
-	Errors map[string]string
+	Errors map[string]Error
 }
 
 // New is a helper which creates a new Validator instance with an empty errors map.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]Error)}
 }
 
 // Valid returns true if the errors map doesn't contain any entries.
@@ -20,19 +33,38 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the map (so long as no entry already exists for
-// the given key).
-func (v *Validator) AddError(key, message string) {
+// AddError adds an error to the map (so long as no entry already exists for the given
+// key). The code should be one of the codes declared in messages.go.
+func (v *Validator) AddError(key, code string, args ...interface{}) {
 	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+		v.Errors[key] = Error{Code: code, Args: args}
 	}
 }
 
-// Check adds an error message to the map only if a validation check is not 'ok'.
-func (v *Validator) Check(ok bool, key, message string) {
+// Check adds an error to the map only if a validation check is not 'ok'.
+func (v *Validator) Check(ok bool, key, code string, args ...interface{}) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(key, code, args...)
+	}
+}
+
+// Messages returns the error messages translated into the given locale, keyed in the
+// same way as the Errors map.
+func (v *Validator) Messages(locale string) map[string]string {
+	messages := make(map[string]string, len(v.Errors))
+	for key, err := range v.Errors {
+		messages[key] = err.Message(locale)
+	}
+	return messages
+}
+
+// Codes returns the error codes, keyed in the same way as the Errors map.
+func (v *Validator) Codes() map[string]string {
+	codes := make(map[string]string, len(v.Errors))
+	for key, err := range v.Errors {
+		codes[key] = err.Code
 	}
+	return codes
 }
 
 // In returns true if a specific value is in a list of strings.
@@ -0,0 +1,568 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The sample key and accept value from section 1.3 of RFC 6455.
+const (
+	sampleKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	sampleAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// frame is a frame received by the test client.
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// clientFrame encodes a frame as a client sends it, masked unless masked is false.
+// The first byte is given whole, so tests can set the reserved bits.
+func clientFrame(first byte, masked bool, payload []byte) []byte {
+	var b []byte
+	b = append(b, first)
+
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+
+	switch length := len(payload); {
+	case length <= 125:
+		b = append(b, maskBit|byte(length))
+	case length <= 0xffff:
+		b = append(b, maskBit|126, byte(length>>8), byte(length))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(length))
+	}
+
+	if !masked {
+		return append(b, payload...)
+	}
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+// closePayload is the payload of a close frame.
+func closePayload(code int, text string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), text...)
+}
+
+// readServerFrame reads a frame written by the server, which must not be masked.
+func readServerFrame(r io.Reader) (frame, error) {
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return frame{}, err
+	}
+	if header[1]&0x80 != 0 {
+		return frame{}, errors.New("server frame is masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(r, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(r, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if err != nil {
+		return frame{}, err
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return frame{}, err
+	}
+
+	return frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0f), payload: payload}, nil
+}
+
+// testClient is the client end of a connection made with newTestConn. Everything the
+// server writes is read straight away and sent to frames, so that the server never
+// blocks on the synchronous pipe.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	frames chan frame
+}
+
+// newTestConn returns a server Conn connected over net.Pipe to a test client.
+func newTestConn(t *testing.T) (*Conn, *testClient) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	tc := &testClient{t: t, conn: client, frames: make(chan frame, 16)}
+	go func() {
+		defer close(tc.frames)
+		for {
+			f, err := readServerFrame(client)
+			if err != nil {
+				return
+			}
+			tc.frames <- f
+		}
+	}()
+
+	return &Conn{conn: server, br: bufio.NewReader(server), readLimit: 64 * 1024}, tc
+}
+
+// send writes frames to the server in the background, since the pipe blocks until
+// the server reads them.
+func (tc *testClient) send(frames ...[]byte) {
+	go func() {
+		for _, f := range frames {
+			_, err := tc.conn.Write(f)
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// next returns the next frame from the server.
+func (tc *testClient) next() frame {
+	tc.t.Helper()
+
+	select {
+	case f, ok := <-tc.frames:
+		if !ok {
+			tc.t.Fatal("connection closed; want a frame")
+		}
+		return f
+	case <-time.After(time.Second):
+		tc.t.Fatal("timed out waiting for a frame")
+	}
+	return frame{}
+}
+
+// expectClose checks that the next frame from the server is a close frame with the
+// code.
+func (tc *testClient) expectClose(code int) {
+	tc.t.Helper()
+
+	f := tc.next()
+	if f.opcode != CloseMessage {
+		tc.t.Fatalf("got opcode %d; want a close frame", f.opcode)
+	}
+	if len(f.payload) < 2 {
+		tc.t.Fatalf("got close payload %q; want a status code", f.payload)
+	}
+	if got := int(binary.BigEndian.Uint16(f.payload)); got != code {
+		tc.t.Errorf("got close code %d; want %d", got, code)
+	}
+}
+
+// expectCloseError checks that err is a *CloseError with the code.
+func expectCloseError(t *testing.T, err error, code int) {
+	t.Helper()
+
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("got error %v; want a *CloseError", err)
+	}
+	if closeErr.Code != code {
+		t.Errorf("got close code %d; want %d", closeErr.Code, code)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	if got := acceptKey(sampleKey); got != sampleAccept {
+		t.Errorf("got %s; want %s", got, sampleAccept)
+	}
+}
+
+func newHandshakeRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", sampleKey)
+	return r
+}
+
+func TestUpgradeBadHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+	}{
+		{"POST", func(r *http.Request) { r.Method = http.MethodPost }},
+		{"wrong version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }},
+		{"no version", func(r *http.Request) { r.Header.Del("Sec-WebSocket-Version") }},
+		{"short key", func(r *http.Request) {
+			r.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(make([]byte, 15)))
+		}},
+		{"long key", func(r *http.Request) {
+			r.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(make([]byte, 17)))
+		}},
+		{"key not base64", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "not base64!") }},
+		{"no key", func(r *http.Request) { r.Header.Del("Sec-WebSocket-Key") }},
+		{"no Upgrade token in Connection", func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }},
+		{"Upgrade not websocket", func(r *http.Request) { r.Header.Set("Upgrade", "h2c") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newHandshakeRequest()
+			tt.modify(r)
+
+			rr := httptest.NewRecorder()
+
+			conn, err := Upgrade(rr, r, nil)
+			if !errors.Is(err, ErrBadHandshake) {
+				t.Fatalf("got error %v; want ErrBadHandshake", err)
+			}
+			if conn != nil {
+				t.Error("got a connection; want nil")
+			}
+			// Nothing has been written, so the caller can still send an error response.
+			if rr.Flushed || rr.Body.Len() > 0 || len(rr.Header()) > 0 {
+				t.Error("the response was written to")
+			}
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	errs := make(chan error, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, http.Header{"X-Test": {"yes"}})
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		// Echo one message back.
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- conn.WriteMessage(messageType, message)
+	}))
+	defer server.Close()
+
+	netConn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	netConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := newHandshakeRequest()
+	r.RequestURI = ""
+	r.URL.Host = server.Listener.Addr().String()
+	err = r.Write(netConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(netConn)
+
+	resp, err := http.ReadResponse(br, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d; want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	for name, want := range map[string]string{
+		"Upgrade":              "websocket",
+		"Connection":           "Upgrade",
+		"Sec-WebSocket-Accept": sampleAccept,
+		"X-Test":               "yes",
+	} {
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("got %s %q; want %q", name, got, want)
+		}
+	}
+
+	// The message is long enough to need the 16-bit extended length both ways.
+	message := bytes.Repeat([]byte("a"), 300)
+
+	_, err = netConn.Write(clientFrame(0x80|TextMessage, true, message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := readServerFrame(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.fin || f.opcode != TextMessage || !bytes.Equal(f.payload, message) {
+		t.Errorf("got frame fin=%t opcode=%d with %d bytes; want the echoed message", f.fin, f.opcode, len(f.payload))
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadMessageProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   int
+	}{
+		{"unmasked frame", [][]byte{clientFrame(0x80|TextMessage, false, []byte("hi"))}, CloseProtocolError},
+		{"reserved bit 1", [][]byte{clientFrame(0xc0|TextMessage, true, []byte("hi"))}, CloseProtocolError},
+		{"reserved bit 3", [][]byte{clientFrame(0x90|TextMessage, true, []byte("hi"))}, CloseProtocolError},
+		{"unknown opcode", [][]byte{clientFrame(0x80|3, true, nil)}, CloseProtocolError},
+		{"unexpected continuation", [][]byte{clientFrame(0x80|continuationFrame, true, []byte("hi"))}, CloseProtocolError},
+		{"new message before the last one ends", [][]byte{
+			clientFrame(TextMessage, true, []byte("one")),
+			clientFrame(0x80|TextMessage, true, []byte("two")),
+		}, CloseProtocolError},
+		{"fragmented ping", [][]byte{clientFrame(PingMessage, true, []byte("hi"))}, CloseProtocolError},
+		{"oversized ping", [][]byte{clientFrame(0x80|PingMessage, true, make([]byte, 126))}, CloseProtocolError},
+		{"oversized close", [][]byte{clientFrame(0x80|CloseMessage, true, make([]byte, 126))}, CloseProtocolError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := newTestConn(t)
+			client.send(tt.frames...)
+
+			_, _, err := conn.ReadMessage()
+			expectCloseError(t, err, tt.code)
+			client.expectClose(tt.code)
+		})
+	}
+}
+
+func TestReadMessageFragmented(t *testing.T) {
+	conn, client := newTestConn(t)
+
+	// "é" is split across the fragments, which is fine as long as the whole message is
+	// valid UTF-8. A ping between fragments is answered straight away, and an
+	// unsolicited pong is ignored.
+	client.send(
+		clientFrame(TextMessage, true, []byte("caf\xc3")),
+		clientFrame(0x80|PingMessage, true, []byte("ping")),
+		clientFrame(continuationFrame, true, []byte("\xa9 ")),
+		clientFrame(0x80|PongMessage, true, []byte("pong")),
+		clientFrame(0x80|continuationFrame, true, []byte("au lait")),
+	)
+
+	messageType, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != TextMessage || string(message) != "café au lait" {
+		t.Errorf("got message %d %q; want %d %q", messageType, message, TextMessage, "café au lait")
+	}
+
+	f := client.next()
+	if f.opcode != PongMessage || string(f.payload) != "ping" {
+		t.Errorf("got frame %d %q; want a pong with the ping's payload", f.opcode, f.payload)
+	}
+
+	// The next message starts afresh.
+	client.send(clientFrame(0x80|BinaryMessage, true, []byte{0xff, 0x00}))
+
+	messageType, message, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != BinaryMessage || !bytes.Equal(message, []byte{0xff, 0x00}) {
+		t.Errorf("got message %d %q; want binary ff00", messageType, message)
+	}
+}
+
+func TestReadMessageLimit(t *testing.T) {
+	t.Run("at the limit", func(t *testing.T) {
+		conn, client := newTestConn(t)
+		conn.SetReadLimit(5)
+
+		client.send(
+			clientFrame(TextMessage, true, []byte("ab")),
+			clientFrame(0x80|continuationFrame, true, []byte("cde")),
+		)
+
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message) != "abcde" {
+			t.Errorf("got %q; want %q", message, "abcde")
+		}
+	})
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"one frame over", [][]byte{clientFrame(0x80|TextMessage, true, []byte("abcdef"))}},
+		{"fragments over", [][]byte{
+			clientFrame(TextMessage, true, []byte("abc")),
+			clientFrame(0x80|continuationFrame, true, []byte("def")),
+		}},
+		// Only the header is sent: the length is refused before any payload is read.
+		{"64-bit length", [][]byte{{0x80 | BinaryMessage, 0x80 | 127, 0x80, 0, 0, 0, 0, 0, 0, 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := newTestConn(t)
+			conn.SetReadLimit(5)
+			client.send(tt.frames...)
+
+			_, _, err := conn.ReadMessage()
+			expectCloseError(t, err, CloseMessageTooBig)
+			client.expectClose(CloseMessageTooBig)
+		})
+	}
+}
+
+func TestReadMessageInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"one frame", [][]byte{clientFrame(0x80|TextMessage, true, []byte("bad \xff byte"))}},
+		{"truncated at the end", [][]byte{
+			clientFrame(TextMessage, true, []byte("caf")),
+			clientFrame(0x80|continuationFrame, true, []byte("\xc3")),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := newTestConn(t)
+			client.send(tt.frames...)
+
+			_, _, err := conn.ReadMessage()
+			expectCloseError(t, err, CloseInvalidPayload)
+			client.expectClose(CloseInvalidPayload)
+		})
+	}
+
+	// Binary messages aren't checked.
+	conn, client := newTestConn(t)
+	client.send(clientFrame(0x80|BinaryMessage, true, []byte("\xff")))
+
+	_, _, err := conn.ReadMessage()
+	if err != nil {
+		t.Errorf("binary message: got error %v; want none", err)
+	}
+}
+
+func TestReadMessageClose(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		wantCode int
+		wantText string
+		echoCode int
+	}{
+		{"with status", closePayload(CloseGoingAway, "bye"), CloseGoingAway, "bye", CloseGoingAway},
+		{"without status", nil, CloseNoStatusReceived, "", CloseNormalClosure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, client := newTestConn(t)
+			client.send(clientFrame(0x80|CloseMessage, true, tt.payload))
+
+			_, _, err := conn.ReadMessage()
+
+			var closeErr *CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("got error %v; want a *CloseError", err)
+			}
+			if closeErr.Code != tt.wantCode || closeErr.Text != tt.wantText {
+				t.Errorf("got %+v; want code %d and text %q", closeErr, tt.wantCode, tt.wantText)
+			}
+
+			client.expectClose(tt.echoCode)
+
+			if err := conn.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrCloseSent) {
+				t.Errorf("WriteMessage after the close: got %v; want ErrCloseSent", err)
+			}
+		})
+	}
+}
+
+func TestWriteClose(t *testing.T) {
+	conn, client := newTestConn(t)
+
+	err := conn.WriteClose(CloseGoingAway, strings.Repeat("x", 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := client.next()
+	if f.opcode != CloseMessage || len(f.payload) != 125 {
+		t.Errorf("got frame %d with %d bytes; want a close frame of 125 bytes", f.opcode, len(f.payload))
+	}
+
+	if err := conn.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("WriteMessage: got %v; want ErrCloseSent", err)
+	}
+	if err := conn.WriteClose(CloseNormalClosure, ""); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("second WriteClose: got %v; want ErrCloseSent", err)
+	}
+
+	// The client's reply completes the handshake without another close frame, and
+	// pings that arrive in the meantime are no longer answered.
+	client.send(
+		clientFrame(0x80|PingMessage, true, nil),
+		clientFrame(0x80|CloseMessage, true, closePayload(CloseGoingAway, "")),
+	)
+
+	_, _, err = conn.ReadMessage()
+	expectCloseError(t, err, CloseGoingAway)
+
+	conn.Close()
+	if f, ok := <-client.frames; ok {
+		t.Errorf("got frame %d after the close; want none", f.opcode)
+	}
+}
+
+func TestWriteMessageLengths(t *testing.T) {
+	for _, length := range []int{0, 125, 126, 0xffff, 0x10000} {
+		conn, client := newTestConn(t)
+
+		message := bytes.Repeat([]byte("z"), length)
+
+		err := conn.WriteMessage(BinaryMessage, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f := client.next()
+		if !f.fin || f.opcode != BinaryMessage || !bytes.Equal(f.payload, message) {
+			t.Errorf("length %d: got frame fin=%t opcode=%d with %d bytes", length, f.fin, f.opcode, len(f.payload))
+		}
+	}
+}
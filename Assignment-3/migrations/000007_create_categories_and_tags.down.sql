@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'categories:write';
+DROP TABLE IF EXISTS remote_cars_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS remote_cars_categories;
+DROP TABLE IF EXISTS categories;
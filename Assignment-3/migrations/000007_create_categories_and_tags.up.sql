@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS remote_cars_categories (
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    category_id bigint NOT NULL REFERENCES categories ON DELETE CASCADE,
+    PRIMARY KEY (remote_car_id, category_id)
+);
+CREATE TABLE IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS remote_cars_tags (
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+    PRIMARY KEY (remote_car_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS remote_cars_tags_tag_id_idx ON remote_cars_tags (tag_id);
+CREATE INDEX IF NOT EXISTS remote_cars_categories_category_id_idx ON remote_cars_categories (category_id);
+INSERT INTO categories (name)
+VALUES
+    ('drift'),
+    ('crawler'),
+    ('off-road'),
+    ('scale');
+INSERT INTO permissions (code)
+VALUES
+    ('categories:write');
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    rating smallint NOT NULL,
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, remote_car_id)
+);
+ALTER TABLE reviews ADD CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 5);
+CREATE INDEX IF NOT EXISTS reviews_remote_car_id_idx ON reviews (remote_car_id);
+INSERT INTO permissions (code)
+VALUES
+    ('reviews:write');
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS favorites (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, remote_car_id)
+);
+CREATE INDEX IF NOT EXISTS favorites_remote_car_id_idx ON favorites (remote_car_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS price_history (
+    id bigserial PRIMARY KEY,
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    cost integer NOT NULL,
+    previous_cost integer,
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS price_history_remote_car_id_idx ON price_history (remote_car_id, changed_at);
+INSERT INTO price_history (remote_car_id, cost, changed_at)
+SELECT id, cost, created_at FROM remote_cars;
+CREATE TABLE IF NOT EXISTS price_alerts (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    threshold integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, remote_car_id)
+);
+ALTER TABLE price_alerts ADD CONSTRAINT price_alerts_threshold_check CHECK (threshold > 0);
+CREATE INDEX IF NOT EXISTS price_alerts_remote_car_id_idx ON price_alerts (remote_car_id);
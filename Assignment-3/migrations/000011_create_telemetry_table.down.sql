@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'telemetry:write';
+DROP TABLE IF EXISTS telemetry;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS telemetry (
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    recorded_at timestamp(3) with time zone NOT NULL,
+    battery_level double precision,
+    signal_strength integer,
+    speed double precision,
+    latitude double precision,
+    longitude double precision,
+    PRIMARY KEY (remote_car_id, recorded_at)
+) PARTITION BY RANGE (recorded_at);
+INSERT INTO permissions (code)
+VALUES
+    ('telemetry:write');
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'remote-cars:control';
+DROP TABLE IF EXISTS commands;
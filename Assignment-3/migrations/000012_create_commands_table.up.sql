@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS commands (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    type text NOT NULL,
+    speed_limit integer,
+    state text NOT NULL DEFAULT 'queued',
+    error text NOT NULL DEFAULT '',
+    expires_at timestamp(0) with time zone NOT NULL,
+    delivered_at timestamp(0) with time zone,
+    completed_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+ALTER TABLE commands ADD CONSTRAINT commands_type_check CHECK (type IN ('start', 'stop', 'lock', 'set_speed_limit', 'locate'));
+ALTER TABLE commands ADD CONSTRAINT commands_state_check CHECK (state IN ('queued', 'delivered', 'acknowledged', 'failed', 'expired'));
+CREATE INDEX IF NOT EXISTS commands_remote_car_id_state_idx ON commands (remote_car_id, state);
+INSERT INTO permissions (code)
+VALUES
+    ('remote-cars:control');
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'devices:write';
+DROP TABLE IF EXISTS devices;
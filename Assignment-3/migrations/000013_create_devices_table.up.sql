@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS devices (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    hardware_id text UNIQUE NOT NULL,
+    remote_car_id bigint NOT NULL REFERENCES remote_cars ON DELETE CASCADE,
+    key_hash bytea UNIQUE NOT NULL,
+    key_created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS devices_remote_car_id_idx ON devices (remote_car_id);
+INSERT INTO permissions (code)
+VALUES
+    ('devices:write');
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS remote_cars_latitude_longitude_idx;
+ALTER TABLE remote_cars DROP CONSTRAINT IF EXISTS remote_cars_longitude_check;
+ALTER TABLE remote_cars DROP CONSTRAINT IF EXISTS remote_cars_latitude_check;
+ALTER TABLE remote_cars DROP CONSTRAINT IF EXISTS remote_cars_location_check;
+ALTER TABLE remote_cars DROP COLUMN IF EXISTS longitude;
+ALTER TABLE remote_cars DROP COLUMN IF EXISTS latitude;
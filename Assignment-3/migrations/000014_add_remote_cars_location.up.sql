@@ -0,0 +1,6 @@
+ALTER TABLE remote_cars ADD COLUMN IF NOT EXISTS latitude double precision;
+ALTER TABLE remote_cars ADD COLUMN IF NOT EXISTS longitude double precision;
+ALTER TABLE remote_cars ADD CONSTRAINT remote_cars_location_check CHECK ((latitude IS NULL) = (longitude IS NULL));
+ALTER TABLE remote_cars ADD CONSTRAINT remote_cars_latitude_check CHECK (latitude BETWEEN -90 AND 90);
+ALTER TABLE remote_cars ADD CONSTRAINT remote_cars_longitude_check CHECK (longitude BETWEEN -180 AND 180);
+CREATE INDEX IF NOT EXISTS remote_cars_latitude_longitude_idx ON remote_cars (latitude, longitude);
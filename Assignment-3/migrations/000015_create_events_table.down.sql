@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS remote_cars_events ON remote_cars;
+DROP FUNCTION IF EXISTS record_event();
+DROP TABLE IF EXISTS events;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    resource text NOT NULL,
+    action text NOT NULL,
+    resource_id bigint NOT NULL
+);
+ALTER TABLE events ADD CONSTRAINT events_action_check CHECK (action IN ('created', 'updated', 'deleted'));
+CREATE INDEX IF NOT EXISTS events_created_at_idx ON events (created_at);
+CREATE OR REPLACE FUNCTION record_event() RETURNS trigger AS $$
+DECLARE
+    event events;
+BEGIN
+    INSERT INTO events (resource, action, resource_id)
+    VALUES (
+        TG_ARGV[0],
+        CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END,
+        CASE TG_OP WHEN 'DELETE' THEN OLD.id ELSE NEW.id END
+    )
+    RETURNING * INTO event;
+    PERFORM pg_notify('events', row_to_json(event)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER remote_cars_events
+    AFTER INSERT OR UPDATE OR DELETE ON remote_cars
+    FOR EACH ROW EXECUTE FUNCTION record_event('remote_car');
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS commands_live ON commands;
+DROP FUNCTION IF EXISTS notify_command_change();
@@ -0,0 +1,13 @@
+CREATE OR REPLACE FUNCTION notify_command_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('remote_car_live', json_build_object(
+        'remote_car_id', NEW.remote_car_id,
+        'type', 'command',
+        'command', row_to_json(NEW)
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER commands_live
+    AFTER INSERT OR UPDATE ON commands
+    FOR EACH ROW EXECUTE FUNCTION notify_command_change();
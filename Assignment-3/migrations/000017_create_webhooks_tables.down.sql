@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'webhooks:write';
+DROP TRIGGER IF EXISTS remote_cars_webhooks ON remote_cars;
+DROP FUNCTION IF EXISTS record_webhook_event();
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_outbox;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    url text NOT NULL,
+    secret text NOT NULL,
+    event_types text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    event_type text NOT NULL,
+    data jsonb NOT NULL,
+    dispatched_at timestamp(0) with time zone
+);
+CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx ON webhook_outbox (id) WHERE dispatched_at IS NULL;
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    outbox_id bigint NOT NULL REFERENCES webhook_outbox ON DELETE CASCADE,
+    state text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (webhook_id, outbox_id)
+);
+ALTER TABLE webhook_deliveries ADD CONSTRAINT webhook_deliveries_state_check CHECK (state IN ('pending', 'succeeded', 'failed'));
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE state = 'pending';
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id bigserial PRIMARY KEY,
+    delivery_id bigint NOT NULL REFERENCES webhook_deliveries ON DELETE CASCADE,
+    attempted_at timestamp(3) with time zone NOT NULL DEFAULT NOW(),
+    response_status integer,
+    error text NOT NULL DEFAULT '',
+    duration_ms integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
+CREATE OR REPLACE FUNCTION record_webhook_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO webhook_outbox (event_type, data)
+    VALUES (
+        TG_ARGV[0] || '.' || CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END,
+        CASE TG_OP WHEN 'DELETE' THEN to_jsonb(OLD) ELSE to_jsonb(NEW) END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER remote_cars_webhooks
+    AFTER INSERT OR UPDATE OR DELETE ON remote_cars
+    FOR EACH ROW EXECUTE FUNCTION record_webhook_event('remote_car');
+INSERT INTO permissions (code)
+VALUES
+    ('webhooks:write');
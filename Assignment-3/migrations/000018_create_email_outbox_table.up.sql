@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    recipient citext NOT NULL,
+    template text NOT NULL,
+    data jsonb NOT NULL,
+    state text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error text NOT NULL DEFAULT '',
+    sent_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_state_check CHECK (state IN ('pending', 'sent', 'dead'));
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS email_outbox_state_idx ON email_outbox (state);
+INSERT INTO permissions (code)
+VALUES
+    ('emails:write');
@@ -0,0 +1,10 @@
+-- The buckets are only worth keeping for a few minutes, so the table is unlogged:
+-- writes are cheaper, and losing it in a database crash just resets the limits.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+    key text PRIMARY KEY,
+    tokens double precision NOT NULL,
+    allowed boolean NOT NULL,
+    updated_at timestamp(6) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_at_idx ON rate_limit_buckets (updated_at);
@@ -0,0 +1,18 @@
+-- A user has at most one TOTP secret. It isn't in use until confirmed_at is set, which
+-- happens once the user has shown they can generate codes from it. last_used_step is
+-- the time step of the last code accepted, so that no code can be used twice.
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret bytea NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    confirmed_at timestamp(0) with time zone,
+    last_used_step bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS two_factor_recovery_codes (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS two_factor_recovery_codes_user_id_idx ON two_factor_recovery_codes (user_id);